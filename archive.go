@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Export writes every file of the store as a tar stream to w, named by its path
+relative to Root. Objects go in as stored, so an archive of a compressed or
+encrypted store needs the same options to be read after an Import. Sidecars
+and kept revisions are included, temp files and the trash are not. With
+CompressionGzip the stream is gzipped.
+
+Objects are streamed one at a time and never buffered in memory. Every file
+is a consistent snapshot, but writes running during the export may or may
+not make it into the archive.
+*/
+func (store *DiskStore) Export(w io.Writer, compression Compression) error {
+	p := store.newProgress(-1)
+	if p != nil {
+		w = &progressWriter{w: w, p: p}
+		defer p.finish()
+	}
+
+	var gz *gzip.Writer
+	if compression == CompressionGzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+
+	base := filepath.Join(store.Root, store.prefix)
+	err := store.exportDir(tw, base, base)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+
+	return nil
+}
+
+func (store *DiskStore) exportDir(tw *tar.Writer, base, dir string) error {
+	d, err := store.FS.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	infos, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+
+		switch {
+		case store.reservedDir(path):
+		case info.IsDir():
+			if err := store.exportDir(tw, base, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		case info.Mode().IsRegular() && !isTempFile(info.Name()):
+			// a file deleted since the listing is simply not part of the export
+			if err := store.exportFile(tw, base, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (store *DiskStore) exportFile(tw *tar.Writer, base, path string) error {
+	file, err := store.openRetry(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the open file's own info, a rename replacing path since doesn't affect it
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(rel),
+		Mode:     int64(info.Mode().Perm()),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+/*
+Import unpacks a tar stream made by Export into the store, gzipped or not,
+replacing files that already exist. Directories are created with DirMode and
+files with FileMode; each file is moved into place atomically. Entries whose
+path would leave Root or lie in a reserved directory are rejected with
+ErrInvalidKey.
+*/
+func (store *DiskStore) Import(r io.Reader) error {
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	r = br
+
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// files replaced behind the counters' back, have the next Stats walk again
+	defer store.counters.invalidate()
+
+	base := filepath.Join(store.Root, store.prefix)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%w: archive entry %q", ErrInvalidKey, header.Name)
+		}
+
+		target := filepath.Join(base, filepath.FromSlash(name))
+		// the store's own state, e.g. a journal replayed on the next open, isn't imported
+		if store.inReservedDir(target) {
+			return fmt.Errorf("%w: archive entry %q", ErrInvalidKey, header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := store.FS.MkdirAll(target, store.DirMode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if !store.insideRoot(target) {
+				return fmt.Errorf("%w: archive entry %q", ErrInvalidKey, header.Name)
+			}
+			if err := store.importFile(target, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+/* importFile stores the content of r at target. */
+func (store *DiskStore) importFile(target string, r io.Reader) error {
+	start := time.Now()
+	cr := &countingReader{r: r}
+
+	err := store.importFileLocked(target, cr)
+	store.Metrics.ObserveWrite(cr.n, time.Since(start), err)
+
+	return err
+}
+
+func (store *DiskStore) importFileLocked(target string, r io.Reader) error {
+	dir := filepath.Dir(target)
+
+	defer store.locks.lock(target)()
+
+	if err := store.FS.MkdirAll(dir, store.DirMode); err != nil {
+		return err
+	}
+
+	store.bloom.add(target)
+	store.checksums.forget(target)
+	return store.writeFile(dir, target, r)
+}
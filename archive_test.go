@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStorageExportImport(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionGzip} {
+		src := newStorage(t)
+		dst := newStorageWithOptions(t, StorageOptions{
+			Root:              "importroot",
+			PathTransformFunc: CASPathTransformFunc,
+		})
+
+		for i := 0; i < 3; i++ {
+			key := fmt.Sprintf("key_%d", i)
+			if _, err := src.WriteWithMeta(key, bytes.NewReader([]byte(key)), map[string]string{"i": key}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var archive bytes.Buffer
+		if err := src.Export(&archive, compression); err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.Import(&archive); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			key := fmt.Sprintf("key_%d", i)
+			if b, err := dst.ReadBytes(key); err != nil || string(b) != key {
+				t.Errorf("expected %q after import, have %q (%v)", key, b, err)
+			}
+			if meta, err := dst.ReadMeta(key); err != nil || meta["i"] != key {
+				t.Errorf("expected metadata of %s after import, have %v (%v)", key, meta, err)
+			}
+		}
+
+		stats, err := dst.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.ObjectCount != 3 {
+			t.Errorf("expected 3 objects after import, have %d", stats.ObjectCount)
+		}
+
+		teardown(t, src)
+		teardown(t, dst)
+	}
+}
+
+func TestStorageImportRejectsEscapingPaths(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../escaped", Mode: 0644, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+
+	if err := s.Import(&archive); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected %v, have %v", ErrInvalidKey, err)
+	}
+}
+
+func TestStorageImportRejectsReservedPaths(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	for _, name := range []string{".journal/injected.json", ".locks/", "./.trash/a/b", "nested/../.checksums/index"} {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		if strings.HasSuffix(name, "/") {
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755})
+		} else {
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: 1})
+			tw.Write([]byte("x"))
+		}
+		tw.Close()
+
+		if err := s.Import(&archive); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("import %q: expected %v, have %v", name, ErrInvalidKey, err)
+		}
+	}
+
+	if infos, _ := readDirFS(s.FS, s.journalRoot()); len(infos) != 0 {
+		t.Errorf("expected nothing to be imported into the journal, have %d entries", len(infos))
+	}
+}
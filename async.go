@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+/*
+WriteAsync stores r under key on a background goroutine and delivers the
+outcome on the returned channel, with the write's error in Err, e.g. to
+pipeline many writes. It waits while BatchWorkers writes of the store are in
+flight already. r is read on the background goroutine, so until the result
+arrived the caller must neither read nor close it, nor change the content
+behind it, like the buffer of a bytes.Reader.
+*/
+func (store *DiskStore) WriteAsync(key string, r io.Reader) <-chan WriteResult {
+	results := make(chan WriteResult, 1)
+
+	store.asyncSlots.acquire(context.Background())
+	go func() {
+		defer store.asyncSlots.release()
+
+		result, err := store.writeStream(context.Background(), key, r)
+		result.Err = err
+		results <- result
+		close(results)
+	}()
+
+	return results
+}
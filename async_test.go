@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStorageWriteAsync(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		BatchWorkers:      2,
+		MaxObjectBytes:    16,
+	})
+	defer teardown(t, s)
+
+	var results []<-chan WriteResult
+	for i := 0; i < 10; i++ {
+		results = append(results, s.WriteAsync(fmt.Sprintf("async%d", i), bytes.NewReader([]byte("pipelined"))))
+	}
+	tooLarge := s.WriteAsync("too-large", bytes.NewReader(make([]byte, 17)))
+
+	for i, ch := range results {
+		result := <-ch
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if result.Bytes != 9 || !result.Created {
+			t.Errorf("unexpected result %+v of write %d", result, i)
+		}
+	}
+	if result := <-tooLarge; !errors.Is(result.Err, ErrObjectTooLarge) {
+		t.Errorf("expected the failed write's error on the channel, have %v", result.Err)
+	}
+
+	if keys, _ := s.Keys(); len(keys) != 10 {
+		t.Errorf("expected 10 objects, have %d", len(keys))
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+WriteBatch stores every reader of items under its key, like a Write per item,
+on up to BatchWorkers goroutines. Keys landing in the same directory share
+its creation, so batches of correlated keys skip most of the MkdirAll calls.
+It returns the bytes stored per successful key along with the errors of the
+failed ones joined into one, each naming its key.
+*/
+func (store *DiskStore) WriteBatch(items map[string]io.Reader) (map[string]int64, error) {
+	if err := store.writable(); err != nil {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		results = make(map[string]int64, len(items))
+		errs    []error
+		dirs    = map[string]*batchDir{}
+	)
+
+	type batchItem struct {
+		key                                string
+		pathnameWithRoot, fullPathWithRoot string
+		r                                  io.Reader
+		dir                                *batchDir
+	}
+
+	work := make([]batchItem, 0, len(items))
+	for key, r := range items {
+		pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("writing (%s): %w", key, err))
+			continue
+		}
+
+		dir, ok := dirs[pathnameWithRoot]
+		if !ok {
+			dir = &batchDir{}
+			dirs[pathnameWithRoot] = dir
+		}
+
+		work = append(work, batchItem{key, pathnameWithRoot, fullPathWithRoot, r, dir})
+	}
+
+	store.parallel(len(work), func(i int) {
+		item := work[i]
+		n, err := store.writeBatchItem(item.key, item.pathnameWithRoot, item.fullPathWithRoot, item.r, item.dir)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("writing (%s): %w", item.key, err))
+			return
+		}
+		results[item.key] = n
+	})
+
+	return results, errors.Join(errs...)
+}
+
+/* batchDir creates the directory shared by the keys of a batch once. */
+type batchDir struct {
+	once sync.Once
+	err  error
+}
+
+func (store *DiskStore) writeBatchItem(key, pathnameWithRoot, fullPathWithRoot string, r io.Reader, dir *batchDir) (int64, error) {
+	start := time.Now()
+
+	dir.once.Do(func() {
+		dir.err = store.FS.MkdirAll(pathnameWithRoot, store.DirMode)
+	})
+	if dir.err != nil {
+		store.Metrics.ObserveWrite(0, time.Since(start), dir.err)
+		return 0, dir.err
+	}
+
+	unlock := store.locks.lock(fullPathWithRoot)
+	result, err := store.writeObjectInDir(context.Background(), pathnameWithRoot, fullPathWithRoot, r)
+	unlock()
+
+	store.Metrics.ObserveWrite(result.Bytes, time.Since(start), err)
+	if err != nil {
+		return 0, err
+	}
+	store.wrote(key, result.Bytes)
+
+	return result.Bytes, nil
+}
+
+/*
+DeleteBatch deletes keys like a Delete per key, on up to BatchWorkers
+goroutines, so missing keys are skipped unless StrictDelete is set. With
+PruneEmptyDirs the directories left empty are pruned once all keys are gone,
+trying each shared directory only once. The errors of the failed keys are
+joined into one, each naming its key.
+*/
+func (store *DiskStore) DeleteBatch(keys []string) error {
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		dirs = make([]string, 0, len(keys))
+	)
+
+	store.parallel(len(keys), func(i int) {
+		key := keys[i]
+
+		start := time.Now()
+		err := store.deleteKey(key, false)
+		store.Metrics.ObserveDelete(time.Since(start), err)
+
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("deleting (%s): %w", key, err))
+			mu.Unlock()
+			return
+		}
+		store.deleted(key)
+
+		if pathnameWithRoot, _, err := store.paths(key); err == nil {
+			mu.Lock()
+			dirs = append(dirs, pathnameWithRoot)
+			mu.Unlock()
+		}
+	})
+
+	if store.PruneEmptyDirs {
+		store.pruneDirs(dirs)
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+pruneDirs is pruneEmptyDirs for many directories at once. Directories are
+tried deepest first and only once, so a parent shared by many of them is only
+tried after all of its pending children are.
+*/
+func (store *DiskStore) pruneDirs(dirs []string) {
+	var (
+		byDepth  = map[int][]string{}
+		seen     = map[string]bool{}
+		maxDepth = -1
+	)
+
+	add := func(dir string) {
+		if !store.insideRoot(dir) || seen[dir] {
+			return
+		}
+		seen[dir] = true
+
+		depth := strings.Count(dir, string(filepath.Separator))
+		byDepth[depth] = append(byDepth[depth], dir)
+		maxDepth = max(maxDepth, depth)
+	}
+
+	for _, dir := range dirs {
+		add(dir)
+	}
+
+	for depth := maxDepth; depth >= 0; depth-- {
+		for _, dir := range byDepth[depth] {
+			// Remove refuses non-empty directories, which is our stop condition
+			if store.FS.Remove(dir) == nil {
+				add(filepath.Dir(dir))
+			}
+		}
+	}
+}
+
+/* parallel calls fn with 0 through n-1 on up to BatchWorkers goroutines and waits for all calls to return. */
+func (store *DiskStore) parallel(n int, fn func(i int)) {
+	workers := min(store.workers(), n)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+/* workers returns how many goroutines batch operations may use. */
+func (store *DiskStore) workers() int {
+	if store.BatchWorkers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return store.BatchWorkers
+}
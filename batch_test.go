@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorageWriteBatch(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{BatchWorkers: 4})
+	defer teardown(t, s)
+
+	items := map[string]io.Reader{}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("shard/key%02d", i)
+		items[key] = strings.NewReader(key)
+	}
+	items["../escaped"] = strings.NewReader("nope")
+
+	results, err := s.WriteBatch(items)
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected %v for the escaping key, have %v", ErrInvalidKey, err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "../escaped") {
+		t.Errorf("expected the error to name its key, have %v", err)
+	}
+
+	if len(results) != 50 {
+		t.Fatalf("expected 50 results, have %d", len(results))
+	}
+	for key, n := range results {
+		if n != int64(len(key)) {
+			t.Errorf("expected %d bytes for %s, have %d", len(key), key, n)
+		}
+
+		b, err := s.ReadBytes(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, []byte(key)) {
+			t.Errorf("unexpected content of %s: %q", key, b)
+		}
+	}
+}
+
+func TestStorageDeleteBatch(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{PruneEmptyDirs: true, BatchWorkers: 4})
+	defer teardown(t, s)
+
+	keys := []string{"docs/a", "docs/b", "docs/c", "other"}
+	for _, key := range keys {
+		if _, err := s.Write(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.DeleteBatch([]string{"docs/a", "docs/b", "docs/c", "missing_key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0] != "other" {
+		t.Errorf("expected only other to remain, have %v", remaining)
+	}
+	if _, err := os.Stat(filepath.Join(s.Root, "docs")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the emptied docs tree to be pruned, have %v", err)
+	}
+
+	s.StrictDelete = true
+	err = s.DeleteBatch([]string{"other", "missing_key"})
+	if !errors.Is(err, ErrKeyNotFound) || !strings.Contains(err.Error(), "missing_key") {
+		t.Errorf("expected %v naming missing_key, have %v", ErrKeyNotFound, err)
+	}
+	if ok, _ := s.Has("other"); ok {
+		t.Error("expected other to be deleted despite the failing key")
+	}
+}
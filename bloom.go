@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"hash/maphash"
+	"math"
+	"os"
+	"sync"
+)
+
+/*
+A BloomFilter store keeps the path of every object in a Bloom filter, sized
+when the store is created for twice the objects Root holds then and at least
+bloomMinCapacity, with a false-positive rate of bloomFalsePositiveRate at that
+capacity. That takes about 1.2 bytes per object of capacity, 1.2 MiB at the
+minimum, 24 MiB for a store of ten million objects. Growing past the capacity
+raises the rate, e.g. to 16% at twice of it; deletes can't clear bits and are
+false positives until the store is created again.
+*/
+const (
+	bloomMinCapacity       = 1 << 20
+	bloomFalsePositiveRate = 0.01
+)
+
+/* bloomFilter is a Bloom filter over object paths, safe for concurrent use. */
+type bloomFilter struct {
+	mu     sync.RWMutex
+	bits   []uint64
+	hashes int
+	seed   maphash.Seed
+}
+
+/* newBloomFilter returns a filter for capacity entries at bloomFalsePositiveRate, hashing with seed. */
+func newBloomFilter(capacity int, seed maphash.Seed) *bloomFilter {
+	capacity = max(capacity, bloomMinCapacity)
+
+	// the optimal m = -n ln p / (ln 2)^2 bits and k = m/n ln 2 hashes
+	m := math.Ceil(-float64(capacity) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(capacity) * math.Ln2)
+
+	return &bloomFilter{
+		bits:   make([]uint64, (int(m)+63)/64),
+		hashes: int(k),
+		seed:   seed,
+	}
+}
+
+/* indexes calls fn with the bit index of each hash of h, derived by double hashing. */
+func (f *bloomFilter) indexes(h uint64, fn func(i uint64)) {
+	h1, h2 := h&math.MaxUint32, h>>32|1
+	m := uint64(len(f.bits)) * 64
+
+	for i := 0; i < f.hashes; i++ {
+		fn((h1 + uint64(i)*h2) % m)
+	}
+}
+
+/* add records s. */
+func (f *bloomFilter) add(s string) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.set(maphash.String(f.seed, s))
+}
+
+/* set sets the bits of hash h; the caller holds mu. */
+func (f *bloomFilter) set(h uint64) {
+	f.indexes(h, func(i uint64) { f.bits[i/64] |= 1 << (i % 64) })
+}
+
+/* mayContain reports false only for strings never added. A nil filter may contain anything. */
+func (f *bloomFilter) mayContain(s string) bool {
+	if f == nil {
+		return true
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	found := true
+	f.indexes(maphash.String(f.seed, s), func(i uint64) { found = found && f.bits[i/64]&(1<<(i%64)) != 0 })
+	return found
+}
+
+/*
+loadBloomFilter walks Root once to fill the filter of a BloomFilter store.
+The filter can only be sized once the objects are counted, so the walk
+collects their hashes rather than their much longer paths.
+*/
+func (store *DiskStore) loadBloomFilter() error {
+	seed := maphash.MakeSeed()
+
+	var hashes []uint64
+	err := store.walkDir(store.Root, func(path string, _ os.FileInfo) error {
+		hashes = append(hashes, maphash.String(seed, path))
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	filter := newBloomFilter(2*len(hashes), seed)
+	for _, h := range hashes {
+		filter.set(h)
+	}
+	store.bloom = filter
+
+	return nil
+}
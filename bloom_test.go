@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"os"
+	"testing"
+)
+
+func TestStorageBloomFilter(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.WriteBytes("before", []byte("stored before the filter")); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newStorageWithOptions(t, StorageOptions{
+		Root:              s.Root,
+		PathTransformFunc: CASPathTransformFunc,
+		BloomFilter:       true,
+	})
+
+	if _, err := s.WriteBytes("after", []byte("stored through the filter")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("after", "moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"before", "moved"} {
+		if ok, err := s.Has(key); err != nil || !ok {
+			t.Errorf("expected to have (%s), have %v (%v)", key, ok, err)
+		}
+	}
+
+	// an object added behind the store's back proves Has trusts the filter
+	dir, path, _ := s.paths("behind")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("unseen"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Has("behind"); ok {
+		t.Error("expected the filter to rule out a key it never saw")
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	f := newBloomFilter(bloomMinCapacity, maphash.MakeSeed())
+	for i := 0; i < bloomMinCapacity; i++ {
+		f.add(fmt.Sprintf("added/%d", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !f.mayContain(fmt.Sprintf("added/%d", i)) {
+			t.Fatalf("expected added/%d to be contained", i)
+		}
+	}
+
+	positives := 0
+	const probes = 100000
+	for i := 0; i < probes; i++ {
+		if f.mayContain(fmt.Sprintf("absent/%d", i)) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / probes; rate > 2*bloomFalsePositiveRate {
+		t.Errorf("expected a false-positive rate around %v, have %v", bloomFalsePositiveRate, rate)
+	}
+}
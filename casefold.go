@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+On a case-insensitive filesystem, such as the defaults of macOS and Windows,
+paths differing only in case name the same file, so keys like "Report" and
+"report" would clobber each other. A CaseInsensitiveFS store therefore escapes
+its paths the way the Go module cache does: every uppercase letter becomes '!'
+followed by its lowercase form and '!' itself is doubled, keeping distinct
+keys on distinct paths. Hex and base32 CAS digests hold neither, so their
+layout is the same either way.
+*/
+const caseEscape = '!'
+
+/* escapeCase returns s with its uppercase letters and escape chars escaped. */
+func escapeCase(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return r == caseEscape || isUpper(r) }) {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == caseEscape:
+			b.WriteRune(caseEscape)
+			b.WriteRune(caseEscape)
+		case isUpper(r):
+			b.WriteRune(caseEscape)
+			b.WriteRune(r - 'A' + 'a')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+/* unescapeCase reverses escapeCase, failing for strings escapeCase never returns. */
+func unescapeCase(s string) (string, bool) {
+	if !strings.ContainsRune(s, caseEscape) {
+		return s, !strings.ContainsFunc(s, isUpper)
+	}
+
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped && r == caseEscape:
+			b.WriteRune(caseEscape)
+		case escaped && 'a' <= r && r <= 'z':
+			b.WriteRune(r - 'a' + 'A')
+		case escaped, isUpper(r):
+			return "", false
+		case r == caseEscape:
+			escaped = true
+			continue
+		default:
+			b.WriteRune(r)
+		}
+		escaped = false
+	}
+	if escaped {
+		return "", false
+	}
+
+	return b.String(), true
+}
+
+/* isUpper only folds ASCII, since filesystems disagree on folding the rest. */
+func isUpper(r rune) bool {
+	return 'A' <= r && r <= 'Z'
+}
+
+/* caseSafePathTransformFunc escapes the paths transform lays keys out to. */
+func caseSafePathTransformFunc(transform PathTransformFunc) PathTransformFunc {
+	return func(key string) PathKey {
+		pathKey := transform(key)
+		return PathKey{
+			Pathname: escapeCase(pathKey.Pathname),
+			Filename: escapeCase(pathKey.Filename),
+		}
+	}
+}
+
+/* foldCase makes a CaseInsensitiveFS store lay keys out on escaped paths. */
+func (store *DiskStore) foldCase() {
+	if store.CaseInsensitiveFS {
+		store.PathTransformFunc = caseSafePathTransformFunc(store.PathTransformFunc)
+	}
+}
+
+/* filenameOf returns the Filename an object file named name was stored under. */
+func (store *DiskStore) filenameOf(name string) string {
+	if !store.CaseInsensitiveFS {
+		return name
+	}
+	if filename, ok := unescapeCase(name); ok {
+		return filename
+	}
+	return name
+}
+
+/*
+probeCaseInsensitive tells whether Root is on a case-insensitive filesystem by
+creating a temp file with uppercase letters in its name and looking it up by
+the lowercased name.
+*/
+func (store *DiskStore) probeCaseInsensitive() (bool, error) {
+	file, err := store.createTempFile(store.Root, "CaseProbe")
+	if err != nil {
+		return false, err
+	}
+	file.Close()
+	defer store.FS.Remove(file.Name())
+
+	lower := filepath.Join(filepath.Dir(file.Name()), strings.ToLower(filepath.Base(file.Name())))
+	_, err = store.FS.Stat(lower)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// caseFoldFS folds the case of every path below root, like a case-insensitive filesystem.
+type caseFoldFS struct {
+	FS
+	root string
+}
+
+func (f *caseFoldFS) fold(name string) string {
+	if rest, ok := strings.CutPrefix(name, f.root); ok {
+		return f.root + strings.ToLower(rest)
+	}
+	return name
+}
+
+func (f *caseFoldFS) MkdirAll(path string, perm os.FileMode) error {
+	return f.FS.MkdirAll(f.fold(path), perm)
+}
+
+func (f *caseFoldFS) Create(name string) (File, error) { return f.FS.Create(f.fold(name)) }
+
+func (f *caseFoldFS) Open(name string) (File, error) { return f.FS.Open(f.fold(name)) }
+
+func (f *caseFoldFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return f.FS.OpenFile(f.fold(name), flag, perm)
+}
+
+func (f *caseFoldFS) Stat(name string) (os.FileInfo, error) { return f.FS.Stat(f.fold(name)) }
+
+func (f *caseFoldFS) Rename(oldname, newname string) error {
+	return f.FS.Rename(f.fold(oldname), f.fold(newname))
+}
+
+func (f *caseFoldFS) Remove(name string) error { return f.FS.Remove(f.fold(name)) }
+
+func (f *caseFoldFS) RemoveAll(path string) error { return f.FS.RemoveAll(f.fold(path)) }
+
+func TestStorageCaseInsensitiveFS(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	s := newStorageWithOptions(t, StorageOptions{
+		Root: root,
+		FS:   &caseFoldFS{FS: OSFS{}, root: root},
+	})
+
+	if !s.CaseInsensitiveFS {
+		t.Fatal("expected the case-insensitive filesystem to be detected")
+	}
+
+	for _, key := range []string{"Report", "report", "re!port"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{"Report", "report", "re!port"} {
+		b, err := s.ReadBytes(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != key {
+			t.Errorf("expected %s to keep its own content, have %q", key, b)
+		}
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"Report", "re!port", "report"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	plain := newStorageWithOptions(t, StorageOptions{Root: filepath.Join(t.TempDir(), "root")})
+	if plain.CaseInsensitiveFS {
+		t.Error("expected a case-sensitive filesystem to be detected as such")
+	}
+}
+
+func TestEscapeCase(t *testing.T) {
+	for _, s := range []string{"", "report", "Report", "RE!PORT", "!!", "docs/A.txt"} {
+		escaped := escapeCase(s)
+		if escaped != strings.ToLower(escaped) {
+			t.Errorf("expected %q to escape to lowercase, have %q", s, escaped)
+		}
+		if unescaped, ok := unescapeCase(escaped); !ok || unescaped != s {
+			t.Errorf("expected %q to round-trip, have (%q, %v)", s, unescaped, ok)
+		}
+	}
+
+	for _, s := range []string{"Report", "re!", "re!1port"} {
+		if _, ok := unescapeCase(s); ok {
+			t.Errorf("expected %q not to unescape", s)
+		}
+	}
+}
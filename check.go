@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+/* layoutProblem is a structural problem of the tree below Root. */
+type layoutProblem struct {
+	kind string
+	path string
+
+	// expected is where a misplaced object belongs, empty when nowhere
+	expected string
+}
+
+const (
+	problemMisplaced = "misplaced object"
+	problemTempFile  = "stray temp file"
+	problemOrphan    = "orphaned sidecar"
+)
+
+func (p layoutProblem) String() string {
+	if p.kind == problemMisplaced && len(p.expected) > 0 {
+		return fmt.Sprintf("%s: %s (belongs at %s)", p.kind, p.path, p.expected)
+	}
+	return fmt.Sprintf("%s: %s", p.kind, p.path)
+}
+
+/*
+Check reports the structural problems of the store, one line each: objects of
+a ContentAddressed store that aren't at the path their key transforms into,
+temp files older than GCGracePeriod and sidecars or revisions whose object is
+gone. Missing directories are no problem, writes create them as needed.
+*/
+func (store *DiskStore) Check() ([]string, error) {
+	problems, err := store.checkLayout()
+	return problemStrings(problems), err
+}
+
+/*
+Repair fixes what Check reports where it can and returns what it fixed: stray
+temp files and orphaned sidecars are removed and misplaced objects are moved
+to where they belong with their metadata, unless an object is already stored
+there. Kept revisions stay behind and are orphans for the next run. When dry
+running, it returns what it would fix.
+*/
+func (store *DiskStore) Repair() ([]string, error) {
+	if err := store.writable(); err != nil {
+		return nil, err
+	}
+
+	problems, err := store.checkLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	var fixed []layoutProblem
+	for _, problem := range problems {
+		ok, err := store.repair(problem)
+		if err != nil {
+			return problemStrings(fixed), err
+		}
+		if ok {
+			fixed = append(fixed, problem)
+		}
+	}
+
+	return problemStrings(fixed), nil
+}
+
+func problemStrings(problems []layoutProblem) []string {
+	lines := make([]string, len(problems))
+	for i, problem := range problems {
+		lines[i] = problem.String()
+	}
+	return lines
+}
+
+/* checkLayout finds the problems below the store's directory, in lexical order of their paths. */
+func (store *DiskStore) checkLayout() ([]layoutProblem, error) {
+	problems := []layoutProblem{}
+	err := store.checkDir(filepath.Join(store.Root, store.prefix), time.Now(), &problems)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+
+	return problems, err
+}
+
+func (store *DiskStore) checkDir(dir string, now time.Time, problems *[]layoutProblem) error {
+	infos, err := readDirFS(store.FS, dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+
+		switch {
+		case store.reservedDir(path):
+		case info.IsDir():
+			if err := store.checkDir(path, now, problems); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		case isTempFile(info.Name()):
+			// younger ones may belong to writes still running
+			if now.Sub(info.ModTime()) > store.GCGracePeriod {
+				*problems = append(*problems, layoutProblem{kind: problemTempFile, path: path})
+			}
+		case isUploadFile(info.Name()):
+		case !isObjectFile(info.Name()):
+			if _, err := store.FS.Stat(sidecarObject(path)); errors.Is(err, os.ErrNotExist) {
+				*problems = append(*problems, layoutProblem{kind: problemOrphan, path: path})
+			}
+		case store.ContentAddressed:
+			_, expected, err := store.paths(store.filenameOf(info.Name()))
+			if err != nil {
+				*problems = append(*problems, layoutProblem{kind: problemMisplaced, path: path})
+			} else if expected != path {
+				*problems = append(*problems, layoutProblem{kind: problemMisplaced, path: path, expected: expected})
+			}
+		}
+	}
+
+	return nil
+}
+
+/* repair fixes problem and reports whether it did. */
+func (store *DiskStore) repair(problem layoutProblem) (bool, error) {
+	switch problem.kind {
+	case problemTempFile:
+		info, err := store.FS.Stat(problem.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, store.removeFile(problem.path, info.Size())
+	case problemOrphan:
+		return store.repairOrphan(problem.path)
+	case problemMisplaced:
+		if len(problem.expected) == 0 {
+			return false, nil
+		}
+		return store.repairMisplaced(problem.path, problem.expected)
+	}
+
+	return false, nil
+}
+
+/* repairOrphan removes the sidecar at path unless its object came back. */
+func (store *DiskStore) repairOrphan(path string) (bool, error) {
+	object := sidecarObject(path)
+	defer store.locks.lock(object)()
+
+	if _, err := store.FS.Stat(object); !errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	info, err := store.FS.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, store.removeFile(path, info.Size())
+}
+
+/* repairMisplaced moves the object at path, with its sidecars, to expected when nothing is there. */
+func (store *DiskStore) repairMisplaced(path, expected string) (bool, error) {
+	defer store.locks.lock(path, expected)()
+
+	if _, err := store.FS.Stat(expected); !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if store.dryRunning() {
+		return true, nil
+	}
+
+	dir := filepath.Dir(expected)
+	if err := store.FS.MkdirAll(dir, store.DirMode); err != nil {
+		return false, err
+	}
+	if err := store.renameRetry(path, expected); err != nil {
+		return false, err
+	}
+	store.bloom.add(expected)
+	store.checksums.rename(path, expected)
+
+	for _, suffix := range []string{metaFileSuffix, refsFileSuffix} {
+		if err := store.renameRetry(path+suffix, expected+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return true, err
+		}
+	}
+	store.Logger.Info("moved misplaced object", "path", path, "to", expected)
+	store.pruneEmptyDirs(filepath.Dir(path))
+
+	return true, store.syncDir(dir)
+}
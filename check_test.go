@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageCheckAndRepair(t *testing.T) {
+	s := newContentAddressedStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("misplaced")
+	key := writeContentAddressed(t, s, data)
+	_, path, _ := s.paths(key)
+
+	misplaced := filepath.Join(s.Root, "lost", key)
+	if err := os.MkdirAll(filepath.Dir(misplaced), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path, misplaced); err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := metaPath(filepath.Join(s.Root, "gone"))
+	if err := os.WriteFile(orphan, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := tempFilePath(s.Root, "crashed")
+	if err := os.WriteFile(stale, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * s.GCGracePeriod)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	young := tempFilePath(s.Root, "running")
+	if err := os.WriteFile(young, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := s.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 3 {
+		t.Fatalf("expected 3 problems, have %q", problems)
+	}
+	for _, want := range []string{misplaced, orphan, stale} {
+		if !strings.Contains(strings.Join(problems, "\n"), want) {
+			t.Errorf("expected %s to be reported, have %q", want, problems)
+		}
+	}
+
+	fixed, err := s.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixed) != 3 {
+		t.Errorf("expected 3 repairs, have %q", fixed)
+	}
+
+	if problems, _ := s.Check(); len(problems) != 0 {
+		t.Errorf("expected no problems after Repair, have %q", problems)
+	}
+	if b, _ := s.ReadBytes(key); string(b) != string(data) {
+		t.Errorf("expected the misplaced object back at its key, have %q", b)
+	}
+	if _, err := os.Stat(young); err != nil {
+		t.Error("expected Repair to leave a young temp file alone")
+	}
+}
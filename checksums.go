@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A ChecksumIndex store records the digest under Hash, the size on disk and the
+last verification of every object it writes in an index below Root. The index
+is a log of JSON lines appended to as objects are written, verified, moved and
+removed, replayed and compacted when the store is created. Objects a store
+didn't write itself, such as ones restored from trash or imported from an
+archive, are only indexed by RebuildIndex.
+*/
+const (
+	checksumsDirName  = ".checksums"
+	checksumsFileName = "index"
+)
+
+func (store *DiskStore) checksumsRoot() string {
+	return filepath.Join(store.Root, checksumsDirName)
+}
+
+/* checksumEntry is what the index knows of the object at Path, relative to Root. */
+type checksumEntry struct {
+	Path     string    `json:"path"`
+	Hash     string    `json:"hash,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Verified time.Time `json:"verified,omitempty"`
+
+	// Removed marks a log line dropping Path from the index
+	Removed bool `json:"removed,omitempty"`
+}
+
+/*
+checksumIndex is the in-memory state of the index, safe for concurrent use. Its
+methods are no-ops on a nil index, the one of stores without ChecksumIndex.
+*/
+type checksumIndex struct {
+	mu      sync.Mutex
+	root    string
+	entries map[string]checksumEntry
+
+	// open opens the log to append every change to, nil for read-only
+	// stores; log is the open log, nil until the first change
+	open   func() (File, error)
+	log    File
+	logger *slog.Logger
+}
+
+/*
+loadChecksumIndex replays the index of Root, rewriting the log without the
+lines later ones superseded when there are more of those than entries.
+*/
+func (store *DiskStore) loadChecksumIndex() error {
+	index := &checksumIndex{root: store.Root, entries: map[string]checksumEntry{}, logger: store.Logger}
+	path := filepath.Join(store.checksumsRoot(), checksumsFileName)
+
+	lines := 0
+	b, err := readFileFS(store.FS, path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry checksumEntry
+		// a line cut short by a crash is the last one and lost
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		index.apply(entry)
+		lines++
+	}
+
+	if !store.ReadOnly {
+		if lines > 2*len(index.entries) {
+			if err := store.writeChecksumIndex(index.entries); err != nil {
+				return err
+			}
+		}
+		index.open = func() (File, error) {
+			if err := store.FS.MkdirAll(filepath.Dir(path), store.DirMode); err != nil {
+				return nil, err
+			}
+			return store.FS.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, store.FileMode)
+		}
+	}
+
+	store.checksums = index
+	return nil
+}
+
+/* writeChecksumIndex atomically replaces the log with one line per entry. */
+func (store *DiskStore) writeChecksumIndex(entries map[string]checksumEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	dir := store.checksumsRoot()
+	if err := store.FS.MkdirAll(dir, store.DirMode); err != nil {
+		return err
+	}
+
+	return store.writeFile(dir, filepath.Join(dir, checksumsFileName), &buf)
+}
+
+/* apply updates the entries with a log line; the caller holds mu or owns index. */
+func (index *checksumIndex) apply(entry checksumEntry) {
+	if entry.Removed {
+		delete(index.entries, entry.Path)
+	} else {
+		index.entries[entry.Path] = entry
+	}
+}
+
+/* record applies entry and appends it to the log; the caller holds mu. */
+func (index *checksumIndex) record(entry checksumEntry) {
+	index.apply(entry)
+	if index.open == nil {
+		return
+	}
+
+	// a lost line leaves the index stale, which RebuildIndex repairs
+	if index.log == nil {
+		log, err := index.open()
+		if err != nil {
+			index.logger.Error("opening checksum index failed", "err", err)
+			return
+		}
+		index.log = log
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if _, err := index.log.Write(append(b, '\n')); err != nil {
+		index.logger.Error("appending to checksum index failed", "path", entry.Path, "err", err)
+	}
+}
+
+func (index *checksumIndex) rel(path string) string {
+	rel, err := filepath.Rel(index.root, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+/* put indexes the object just written to path, counting it as verified now. */
+func (index *checksumIndex) put(path, digest string, size int64) {
+	if index == nil {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.record(checksumEntry{Path: index.rel(path), Hash: digest, Size: size, Verified: time.Now()})
+}
+
+/* verified records that the object at path matched its digest just now. */
+func (index *checksumIndex) verified(path string) {
+	if index == nil {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if entry, ok := index.entries[index.rel(path)]; ok {
+		entry.Verified = time.Now()
+		index.record(entry)
+	}
+}
+
+/* verifiedAt returns when the object at path was last verified, zero if it never was. */
+func (index *checksumIndex) verifiedAt(path string) time.Time {
+	if index == nil {
+		return time.Time{}
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	return index.entries[index.rel(path)].Verified
+}
+
+/* rename moves the entry of the object at src, if any, to dst. */
+func (index *checksumIndex) rename(src, dst string) {
+	if index == nil {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if entry, ok := index.entries[index.rel(src)]; ok {
+		index.record(checksumEntry{Path: entry.Path, Removed: true})
+		entry.Path = index.rel(dst)
+		index.record(entry)
+	} else if _, ok := index.entries[index.rel(dst)]; ok {
+		index.record(checksumEntry{Path: index.rel(dst), Removed: true})
+	}
+}
+
+/* forget drops the entries of the objects at paths, which were removed or changed by other means than a write. */
+func (index *checksumIndex) forget(paths ...string) {
+	if index == nil {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	for _, path := range paths {
+		if _, ok := index.entries[index.rel(path)]; ok {
+			index.record(checksumEntry{Path: index.rel(path), Removed: true})
+		}
+	}
+}
+
+/* forgetDir drops the entries of all objects in dir and its subdirectories. */
+func (index *checksumIndex) forgetDir(dir string) {
+	if index == nil {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	prefix := index.rel(dir) + "/"
+	for rel := range index.entries {
+		if strings.HasPrefix(rel, prefix) {
+			index.record(checksumEntry{Path: rel, Removed: true})
+		}
+	}
+}
+
+/* reset empties the index of a store whose objects, index included, were all removed. */
+func (index *checksumIndex) reset() {
+	if index == nil {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.entries = map[string]checksumEntry{}
+	index.closeLog()
+}
+
+/* close closes the log; later changes are only kept in memory. */
+func (index *checksumIndex) close() error {
+	if index == nil {
+		return nil
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.open = nil
+	return index.closeLog()
+}
+
+/* closeLog closes the log, to be opened again by the next change; the caller holds mu. */
+func (index *checksumIndex) closeLog() error {
+	if index.log == nil {
+		return nil
+	}
+
+	err := index.log.Close()
+	index.log = nil
+	return err
+}
+
+/* snapshot returns a copy of the entries. */
+func (index *checksumIndex) snapshot() []checksumEntry {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	entries := make([]checksumEntry, 0, len(index.entries))
+	for _, entry := range index.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+/* newIndexHash returns the hash of content to index, nil without ChecksumIndex. */
+func (store *DiskStore) newIndexHash() hash.Hash {
+	if store.checksums == nil {
+		return nil
+	}
+	return store.Hash()
+}
+
+/* ErrNoChecksumIndex is returned by VerifyIndex and RebuildIndex on stores without ChecksumIndex. */
+var ErrNoChecksumIndex = errors.New("store keeps no checksum index")
+
+/*
+VerifyIndex is the cheap check of the checksum index: without reading any
+content, it returns the keys of the indexed objects that are missing or whose
+size on disk changed since they were indexed.
+*/
+func (store *DiskStore) VerifyIndex() ([]string, error) {
+	if store.checksums == nil {
+		return nil, ErrNoChecksumIndex
+	}
+
+	entries := store.checksums.snapshot()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	bad := []string{}
+	for _, entry := range entries {
+		path := filepath.Join(store.Root, filepath.FromSlash(entry.Path))
+
+		info, err := store.FS.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return bad, err
+		}
+		if info == nil || info.Size() != entry.Size {
+			bad = append(bad, store.filenameOf(filepath.Base(path)))
+		}
+	}
+
+	return bad, nil
+}
+
+/*
+RebuildIndex replaces the checksum index with one of every object in the
+store, hashing all of their content. An object of a ContentAddressed store
+counts as verified when its digest matches its Filename.
+*/
+func (store *DiskStore) RebuildIndex() error {
+	if store.checksums == nil {
+		return ErrNoChecksumIndex
+	}
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	entries := map[string]checksumEntry{}
+	err := store.walkDir(store.Root, func(path string, info os.FileInfo) error {
+		digest, err := store.digestFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := checksumEntry{Path: store.checksums.rel(path), Hash: digest, Size: info.Size()}
+		if store.ContentAddressed && digest == store.filenameOf(info.Name()) {
+			entry.Verified = time.Now()
+		}
+		entries[entry.Path] = entry
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	index := store.checksums
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if err := store.writeChecksumIndex(entries); err != nil {
+		return err
+	}
+	index.entries = entries
+
+	// the old log file was replaced, later lines go to the new one
+	return index.closeLog()
+}
+
+/* digestFile returns the hex digest under Hash of the decoded content of the object at path. */
+func (store *DiskStore) digestFile(path string) (string, error) {
+	file, err := store.openRetry(path)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := store.decode(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	hasher := store.Hash()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func newChecksumIndexStorage(t *testing.T) *DiskStore {
+	return newStorageWithOptions(t, StorageOptions{
+		Root:              "casroot",
+		PathTransformFunc: NewDigestPathTransformFunc(5),
+		ContentAddressed:  true,
+		ChecksumIndex:     true,
+	})
+}
+
+func TestStorageVerifyIndex(t *testing.T) {
+	s := newChecksumIndexStorage(t)
+	defer teardown(t, s)
+
+	kept := writeContentAddressed(t, s, []byte("kept"))
+	truncated := writeContentAddressed(t, s, []byte("truncated"))
+	deleted := writeContentAddressed(t, s, []byte("deleted"))
+
+	if err := s.Delete(deleted); err != nil {
+		t.Fatal(err)
+	}
+	_, path, _ := s.paths(truncated)
+	if err := os.Truncate(path, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	bad, err := s.VerifyIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bad) != 1 || bad[0] != truncated {
+		t.Errorf("expected only %s to be reported, have %v", truncated, bad)
+	}
+
+	// the index survives the store
+	s.Close()
+	s = newChecksumIndexStorage(t)
+	defer s.Close()
+
+	if bad, _ := s.VerifyIndex(); len(bad) != 1 || bad[0] != truncated {
+		t.Errorf("expected the reopened index to report %s, have %v", truncated, bad)
+	}
+
+	if err := s.RebuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if bad, _ := s.VerifyIndex(); len(bad) != 0 {
+		t.Errorf("expected nothing to report after RebuildIndex, have %v", bad)
+	}
+
+	_, keptPath, _ := s.paths(kept)
+	if s.checksums.verifiedAt(keptPath).IsZero() {
+		t.Error("expected RebuildIndex to count matching content as verified")
+	}
+	if !s.checksums.verifiedAt(path).IsZero() {
+		t.Error("expected RebuildIndex not to count corrupted content as verified")
+	}
+}
+
+func TestStorageVerifyOldestFirst(t *testing.T) {
+	s := newChecksumIndexStorage(t)
+	defer teardown(t, s)
+	defer s.Close()
+
+	// first is the one a lexical scrub would start with
+	first := writeContentAddressed(t, s, []byte("first"))
+	second := writeContentAddressed(t, s, []byte("second"))
+	if second < first {
+		first, second = second, first
+	}
+	_, firstPath, _ := s.paths(first)
+	_, secondPath, _ := s.paths(second)
+
+	if _, err := s.VerifiedRead(first); err != nil {
+		t.Fatal(err)
+	}
+	verified := s.checksums.verifiedAt(firstPath)
+
+	time.Sleep(time.Millisecond)
+	if _, err := s.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.checksums.verifiedAt(secondPath).Before(s.checksums.verifiedAt(firstPath)) {
+		t.Error("expected Verify to scrub the object verified longest ago first")
+	}
+	if !s.checksums.verifiedAt(firstPath).After(verified) {
+		t.Error("expected Verify to record its verification")
+	}
+}
+
+func TestStorageVerifyIndexDisabled(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.VerifyIndex(); err != ErrNoChecksumIndex {
+		t.Errorf("expected %v, have %v", ErrNoChecksumIndex, err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+/*
+A chunked object is a JSON manifest stored under its key, listing the chunks
+its content was split into. Every chunk is an object of its own, keyed by the
+hex digest of its content under Hash, so identical chunks of different
+objects are stored once. On a ContentAddressed store chunks therefore are
+regular content-addressed objects.
+*/
+type chunkManifest struct {
+	Size      int64      `json:"size"`
+	ChunkSize int64      `json:"chunkSize"`
+	Chunks    []chunkRef `json:"chunks"`
+}
+
+type chunkRef struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+/*
+ChunkedWrite splits the content of r into chunks of chunkSize bytes, stores
+those not stored yet and then the manifest under key, returning the content
+length. A whole chunk is kept in memory at a time. Deleting key only removes
+the manifest, as other objects may share its chunks.
+*/
+func (store *DiskStore) ChunkedWrite(key string, r io.Reader, chunkSize int64) (int64, error) {
+	if chunkSize <= 0 {
+		return 0, fmt.Errorf("storage: chunk size must be positive, have %d", chunkSize)
+	}
+	if err := store.writable(); err != nil {
+		return 0, err
+	}
+
+	manifest := chunkManifest{ChunkSize: chunkSize, Chunks: []chunkRef{}}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk, werr := store.writeChunk(buf[:n])
+			if werr != nil {
+				return 0, werr
+			}
+			manifest.Chunks = append(manifest.Chunks, chunk)
+			manifest.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := store.Write(key, bytes.NewReader(b)); err != nil {
+		return 0, err
+	}
+
+	return manifest.Size, nil
+}
+
+/* writeChunk stores b under its digest unless a chunk with that digest is stored already. */
+func (store *DiskStore) writeChunk(b []byte) (chunkRef, error) {
+	h := store.Hash()
+	h.Write(b)
+	chunk := chunkRef{Digest: hex.EncodeToString(h.Sum(nil)), Size: int64(len(b))}
+
+	if _, _, err := store.WriteIfNotExists(chunk.Digest, bytes.NewReader(b)); err != nil && !errors.Is(err, ErrKeyExists) {
+		return chunkRef{}, fmt.Errorf("writing chunk %s: %w", chunk.Digest, err)
+	}
+
+	return chunk, nil
+}
+
+/*
+ChunkedRead returns the content of the chunked object stored under key,
+reading its chunks one after the other. Every chunk is checked against its
+digest, failing the read with ErrCorrupted on a mismatch.
+*/
+func (store *DiskStore) ChunkedRead(key string) (io.ReadCloser, error) {
+	b, err := store.ReadBytes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest chunkManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("reading chunk manifest (%s): %w", key, err)
+	}
+
+	return &chunkReader{store: store, chunks: manifest.Chunks, hash: store.Hash()}, nil
+}
+
+/* chunkReader stitches the chunks of a manifest back together, verifying each. */
+type chunkReader struct {
+	store  *DiskStore
+	chunks []chunkRef
+
+	// current is the open chunk, nil between chunks
+	current io.ReadCloser
+	hash    hash.Hash
+	n       int64
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if cr.current == nil {
+			if len(cr.chunks) == 0 {
+				return 0, io.EOF
+			}
+
+			r, err := cr.store.readStream(cr.chunks[0].Digest)
+			if err != nil {
+				return 0, fmt.Errorf("reading chunk %s: %w", cr.chunks[0].Digest, err)
+			}
+			cr.current, cr.n = r, 0
+			cr.hash.Reset()
+		}
+
+		n, err := cr.current.Read(p)
+		cr.hash.Write(p[:n])
+		cr.n += int64(n)
+
+		if err == io.EOF {
+			if verr := cr.finishChunk(); verr != nil {
+				return n, verr
+			}
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+/* finishChunk closes the current chunk once it was read to its end and verifies it. */
+func (cr *chunkReader) finishChunk() error {
+	chunk := cr.chunks[0]
+	cr.chunks = cr.chunks[1:]
+
+	err := cr.current.Close()
+	cr.current = nil
+	if err != nil {
+		return err
+	}
+
+	if digest := hex.EncodeToString(cr.hash.Sum(nil)); cr.n != chunk.Size || digest != chunk.Digest {
+		return fmt.Errorf("%w: chunk %s has %d bytes with digest %s", ErrCorrupted, chunk.Digest, cr.n, digest)
+	}
+
+	return nil
+}
+
+func (cr *chunkReader) Close() error {
+	if cr.current == nil {
+		return nil
+	}
+
+	err := cr.current.Close()
+	cr.current = nil
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStorageChunked(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{PathTransformFunc: CASPathTransformFunc})
+	defer teardown(t, s)
+
+	// the first two chunks are identical and stored once
+	content := append(bytes.Repeat([]byte("a"), 20), []byte("tail")...)
+	n, err := s.ChunkedWrite("big", bytes.NewReader(content), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("expected %d bytes written, have %d", len(content), n)
+	}
+
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ObjectCount != 3 {
+		t.Errorf("expected the manifest and 2 distinct chunks, have %d objects", stats.ObjectCount)
+	}
+
+	r, err := s.ChunkedRead("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	// a chunk changed behind the store's back fails the read
+	digest := sha256.Sum256([]byte("tail"))
+	_, chunkPath, _ := s.paths(hex.EncodeToString(digest[:]))
+	if err := os.WriteFile(chunkPath, []byte("tall"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = s.ChunkedRead("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected %v, have %v", ErrCorrupted, err)
+	}
+}
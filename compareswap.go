@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+/* ErrCASConflict is returned by WriteCAS when the key's content isn't the expected one. */
+var ErrCASConflict = errors.New("compare-and-swap conflict")
+
+/*
+CASConflictError is the ErrCASConflict of a WriteCAS, carrying the hash of
+what the key holds instead, e.g. to retry against. Actual is empty when the key
+held nothing.
+*/
+type CASConflictError struct {
+	Key    string
+	Actual string
+}
+
+func (e *CASConflictError) Error() string {
+	if len(e.Actual) == 0 {
+		return fmt.Sprintf("%s (%s): key holds nothing", ErrCASConflict, e.Key)
+	}
+	return fmt.Sprintf("%s (%s): current hash is %s", ErrCASConflict, e.Key, e.Actual)
+}
+
+func (e *CASConflictError) Unwrap() error {
+	return ErrCASConflict
+}
+
+/*
+WriteCAS stores r under key only if the content key currently holds has the hex
+digest expectedHash under Hash, as returned by WriteWithHash, or if key holds
+nothing when expectedHash is empty. Otherwise it fails with a
+*CASConflictError. The compare and the swap happen under the key's lock, so of
+two writers expecting the same hash only one succeeds. Comparing means hashing
+the current content, which is read in full.
+*/
+func (store *DiskStore) WriteCAS(key string, r io.Reader, expectedHash string) (int64, error) {
+	if err := store.writable(); err != nil {
+		return 0, err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	unlock := store.locks.lock(fullPathWithRoot)
+	actual, err := store.currentHash(key, fullPathWithRoot)
+	if err == nil && actual != expectedHash {
+		err = &CASConflictError{Key: key, Actual: actual}
+	}
+	var result WriteResult
+	if err == nil {
+		result, err = store.writeObject(context.Background(), pathnameWithRoot, fullPathWithRoot, r)
+	}
+	unlock()
+
+	store.Metrics.ObserveWrite(result.Bytes, time.Since(start), err)
+	if err != nil {
+		return 0, err
+	}
+	store.wrote(key, result.Bytes)
+
+	return result.Bytes, nil
+}
+
+/* currentHash returns the hex digest of what key holds, empty when it holds nothing. */
+func (store *DiskStore) currentHash(key, fullPathWithRoot string) (string, error) {
+	_, err := store.stat(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	file, err := store.openObject(key, fullPathWithRoot)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := store.Hash()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
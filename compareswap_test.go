@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestStorageWriteCAS(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.WriteCAS("counter", bytes.NewReader([]byte("1")), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.WriteCAS("counter", bytes.NewReader([]byte("1")), "")
+	var conflict *CASConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrCASConflict) {
+		t.Fatalf("expected a CAS conflict for an existing key, have %v", err)
+	}
+
+	_, hash, err := s.WriteWithHash("counter", bytes.NewReader([]byte("2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conflict.Actual == hash {
+		t.Error("expected the conflict to carry the hash of the old content")
+	}
+
+	if _, err := s.WriteCAS("counter", bytes.NewReader([]byte("3")), hash); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := s.ReadBytes("counter"); string(b) != "3" {
+		t.Errorf("expected the swap to store 3, have %q", b)
+	}
+}
+
+func TestStorageWriteCASConcurrent(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	_, hash, err := s.WriteWithHash("contended", bytes.NewReader([]byte("initial")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.WriteCAS("contended", bytes.NewReader([]byte{byte('a' + i)}), hash)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrCASConflict) {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Errorf("expected exactly one writer to win, have %d", succeeded)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+/* ContentTypeMetaKey is the metadata entry DetectContentType keeps the sniffed MIME type in. */
+const ContentTypeMetaKey = "Content-Type"
+
+/* sniffLen is how much content http.DetectContentType looks at. */
+const sniffLen = 512
+
+/*
+sniffContentType detects the MIME type of the content of r and returns a
+reader yielding that content in full, its first bytes buffered in front of
+the rest of r.
+*/
+func sniffContentType(r io.Reader) (string, io.Reader, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", nil, err
+	}
+	buf = buf[:n]
+
+	return http.DetectContentType(buf), io.MultiReader(bytes.NewReader(buf), r), nil
+}
+
+/*
+ContentType returns the MIME type of the object stored under key, as sniffed
+when it was written by a DetectContentType store or, for objects without one,
+from its first bytes now. A missing key yields ErrKeyNotFound.
+*/
+func (store *DiskStore) ContentType(key string) (string, error) {
+	meta, err := store.ReadMeta(key)
+	if err != nil {
+		return "", err
+	}
+	if contentType, ok := meta[ContentTypeMetaKey]; ok {
+		return contentType, nil
+	}
+
+	r, err := store.openKey(key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	contentType, _, err := sniffContentType(r)
+	return contentType, err
+}
+
+/* setContentType records contentType in the metadata of the object at fullPathWithRoot, keeping the rest. */
+func (store *DiskStore) setContentType(pathnameWithRoot, fullPathWithRoot, contentType string) error {
+	meta, err := store.readMeta(fullPathWithRoot)
+	if err != nil {
+		return err
+	}
+	if meta[ContentTypeMetaKey] == contentType {
+		return nil
+	}
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	meta[ContentTypeMetaKey] = contentType
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return store.writeMeta(pathnameWithRoot, fullPathWithRoot, b)
+}
+
+/* withContentType returns meta with contentType added, unless it is empty or meta has one already. */
+func withContentType(meta map[string]string, contentType string) map[string]string {
+	if _, ok := meta[ContentTypeMetaKey]; ok || len(contentType) == 0 {
+		return meta
+	}
+
+	merged := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		merged[k] = v
+	}
+	merged[ContentTypeMetaKey] = contentType
+	return merged
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStorageDetectContentType(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{PathTransformFunc: CASPathTransformFunc, DetectContentType: true})
+	defer teardown(t, s)
+
+	// longer than what is sniffed, so the rest must follow the peeked bytes
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{7}, 2*sniffLen)...)
+	result, err := s.WriteReport("image", bytes.NewReader(png))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ContentType != "image/png" {
+		t.Errorf("expected the write to report image/png, have %q", result.ContentType)
+	}
+	if b, _ := s.ReadBytes("image"); !bytes.Equal(b, png) {
+		t.Error("expected sniffing to leave the content intact")
+	}
+	if contentType, _ := s.ContentType("image"); contentType != "image/png" {
+		t.Errorf("expected image/png, have %q", contentType)
+	}
+
+	if _, err := s.WriteWithMeta("notes", bytes.NewReader([]byte("# title")), map[string]string{ContentTypeMetaKey: "text/markdown"}); err != nil {
+		t.Fatal(err)
+	}
+	if contentType, _ := s.ContentType("notes"); contentType != "text/markdown" {
+		t.Errorf("expected the given type to win over the sniffed one, have %q", contentType)
+	}
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if have := resp.Header.Get("Content-Type"); have != "text/markdown" {
+		t.Errorf("expected the handler to send the recorded type, have %q", have)
+	}
+}
+
+func TestStorageContentTypeSniffedOnRead(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("page", bytes.NewReader([]byte("<html><body>hi</body></html>"))); err != nil {
+		t.Fatal(err)
+	}
+	if contentType, _ := s.ContentType("page"); contentType != "text/html; charset=utf-8" {
+		t.Errorf("expected the type of an unrecorded object to be sniffed, have %q", contentType)
+	}
+	if _, err := s.ContentType("missing"); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
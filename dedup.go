@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"hash"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+/*
+dedupDirName is the directory below Root indexing the content of a Dedup
+store: every distinct content is hardlinked there under its hex digest, so a
+write of the same content can link to it rather than storing it again. Keys
+sharing content are plain hardlinks of one file, deleting or overwriting one of
+them leaves the others intact.
+*/
+const dedupDirName = ".dedup"
+
+func (store *DiskStore) dedupRoot() string {
+	return filepath.Join(store.Root, dedupDirName)
+}
+
+/* dedupPath returns where content with the hex digest is indexed, fanned out by its first two digits. */
+func (store *DiskStore) dedupPath(digest string) string {
+	return filepath.Join(store.dedupRoot(), digest[:2], digest)
+}
+
+/* deduplicating reports whether writes look for identical content to link to. */
+func (store *DiskStore) deduplicating() bool {
+	_, ok := store.FS.(Linker)
+	return store.Dedup && !store.ContentAddressed && ok
+}
+
+/* newDedupHash returns the hash of content to deduplicate on, nil when not deduplicating. */
+func (store *DiskStore) newDedupHash() hash.Hash {
+	if !store.deduplicating() {
+		return nil
+	}
+	return store.Hash()
+}
+
+/*
+dedup swaps the finished temp file tmp, holding content with the hex digest,
+for a link to the identical content already indexed, and indexes tmp's
+content otherwise. It returns the file to rename into place. The index is
+best effort: any failure to use it leaves tmp to be stored as it is.
+*/
+func (store *DiskStore) dedup(tmp, digest string) string {
+	linker := store.FS.(Linker)
+	indexed := store.dedupPath(digest)
+
+	link := tempFilePath(filepath.Dir(tmp), filepath.Base(tmp))
+	if err := linker.Link(indexed, link); err == nil {
+		// the shared file is as new as its latest write, for TTL and eviction
+		now := time.Now()
+		store.FS.Chtimes(link, now, now)
+		store.FS.Remove(tmp)
+		return link
+	}
+
+	if err := store.FS.MkdirAll(filepath.Dir(indexed), store.DirMode); err == nil {
+		// with TempDir on another filesystem there is nothing to link
+		if err := linker.Link(tmp, indexed); err != nil && !errors.Is(err, os.ErrExist) && !errors.Is(err, syscall.EXDEV) {
+			store.Logger.Error("indexing content for dedup failed", "path", indexed, "err", err)
+		}
+	}
+
+	return tmp
+}
+
+/*
+gcDedup removes the index entries of content no key links to anymore, counting
+them as Orphans. This needs the link count of files, which is only known on
+Unix; elsewhere the index is left to grow.
+*/
+func (store *DiskStore) gcDedup(report *GCReport) error {
+	root := store.dedupRoot()
+
+	fans, err := readDirFS(store.FS, root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, fan := range fans {
+		dir := filepath.Join(root, fan.Name())
+		infos, err := readDirFS(store.FS, dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		for _, info := range infos {
+			// a write linking to it meanwhile keeps the content, only the index entry is gone
+			if n, ok := linkCount(info); !ok || n > 1 {
+				continue
+			}
+			if err := store.removeFile(filepath.Join(dir, info.Name()), info.Size()); err != nil {
+				return err
+			}
+			report.Orphans++
+			report.Bytes += info.Size()
+		}
+	}
+
+	return nil
+}
+
+/* readDirFS lists the entries of the directory at name in fsys. */
+func readDirFS(fsys FS, name string) ([]os.FileInfo, error) {
+	d, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	return d.Readdir(-1)
+}
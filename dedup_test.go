@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStorageDedup(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Dedup:             true,
+		GCGracePeriod:     -1,
+	})
+	defer teardown(t, s)
+
+	for _, key := range []string{"a", "b"} {
+		if _, err := s.WriteBytes(key, []byte("shared content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infoOf := func(key string) os.FileInfo {
+		_, fullPath, _ := s.paths(key)
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	if !os.SameFile(infoOf("a"), infoOf("b")) {
+		t.Fatal("expected identical content to be stored once")
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.ReadBytes("b"); err != nil || string(b) != "shared content" {
+		t.Errorf("expected deleting a to leave b intact, have %q (%v)", b, err)
+	}
+
+	if _, err := s.WriteBytes("b", []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteBytes("c", []byte("shared content")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := s.ReadBytes("c"); string(b) != "shared content" {
+		t.Errorf("expected the index to outlive its keys, have %q", b)
+	}
+
+	if _, err := s.OpenReadWrite("c"); err == nil {
+		t.Error("expected in-place modification to be refused")
+	}
+
+	if err := s.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Orphans != 1 {
+		t.Errorf("expected GC to drop the content no key holds, have %+v", report)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+/* DryRunReport lists what a dry run of destructive operations would have removed. */
+type DryRunReport struct {
+	/* Paths are the files and directories, contents before the directory holding them. */
+	Paths []string
+
+	/* Bytes is the size of all files listed. */
+	Bytes int64
+}
+
+/* dryRun collects the DryRunReport of a Preview. */
+type dryRun struct {
+	mu     sync.Mutex
+	report DryRunReport
+}
+
+/*
+Preview calls fn with a view of the store in dry-run mode, as though DryRun
+were set, and returns everything the Clear, GC and DeletePrefix calls of fn
+would have removed. Nothing is changed, so e.g. a Clear before a GC in fn
+doesn't keep the GC from listing what it would collect.
+*/
+func (store *DiskStore) Preview(fn func(preview *DiskStore) error) (DryRunReport, error) {
+	view := *store
+	view.dryRun = &dryRun{}
+	view.rootLock = nil
+
+	err := fn(&view)
+
+	view.dryRun.mu.Lock()
+	defer view.dryRun.mu.Unlock()
+
+	return view.dryRun.report, err
+}
+
+/* dryRunning reports whether destructive operations only report what they would remove. */
+func (store *DiskStore) dryRunning() bool {
+	return store.DryRun || store.dryRun != nil
+}
+
+/* wouldRemove logs, and in a Preview records, that path of size bytes would be removed. */
+func (store *DiskStore) wouldRemove(path string, size int64) {
+	store.Logger.Info("dry run: would remove", "path", path, "bytes", size)
+
+	if store.dryRun == nil {
+		return
+	}
+
+	store.dryRun.mu.Lock()
+	defer store.dryRun.mu.Unlock()
+
+	store.dryRun.report.Paths = append(store.dryRun.report.Paths, path)
+	store.dryRun.report.Bytes += size
+}
+
+/* removeFile removes the file or empty directory at path, of size bytes, unless dry running. */
+func (store *DiskStore) removeFile(path string, size int64) error {
+	if store.dryRunning() {
+		store.wouldRemove(path, size)
+		return nil
+	}
+
+	return store.FS.Remove(path)
+}
+
+/* removeAll is FS.RemoveAll, reporting every path below path instead when dry running. */
+func (store *DiskStore) removeAll(path string) error {
+	if !store.dryRunning() {
+		return store.FS.RemoveAll(path)
+	}
+
+	info, err := store.FS.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		store.wouldRemove(path, info.Size())
+		return nil
+	}
+
+	d, err := store.FS.Open(path)
+	if err != nil {
+		return err
+	}
+	infos, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	for _, info := range infos {
+		if err := store.removeAll(filepath.Join(path, info.Name())); err != nil {
+			return err
+		}
+	}
+	store.wouldRemove(path, 0)
+
+	return nil
+}
+
+/* wouldRemoveSidecars reports the sidecars removed along with the object at fullPathWithRoot. */
+func (store *DiskStore) wouldRemoveSidecars(fullPathWithRoot string) {
+	for _, path := range []string{refsPath(fullPathWithRoot), metaPath(fullPathWithRoot)} {
+		if info, err := store.FS.Stat(path); err == nil {
+			store.wouldRemove(path, info.Size())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoragePreview(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{PathTransformFunc: DefaultPathTransformFunc})
+	defer teardown(t, s)
+
+	for _, key := range []string{"logs/a", "logs/b", "keep"} {
+		if _, err := s.WriteBytes(key, []byte("12345")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := s.Preview(func(preview *DiskStore) error {
+		n, err := preview.DeletePrefix("logs")
+		if n != 2 {
+			t.Errorf("expected DeletePrefix to report 2 objects, have %d", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := strings.Join(report.Paths, ",")
+	for _, key := range []string{"logs/a", "logs/b"} {
+		if _, fullPath, _ := s.paths(key); !strings.Contains(paths, fullPath) {
+			t.Errorf("expected (%s) to be listed, have %v", key, report.Paths)
+		}
+	}
+	if last := report.Paths[len(report.Paths)-1]; last != filepath.Join(s.Root, "logs") {
+		t.Errorf("expected the prefix directory to be listed last, have %s", last)
+	}
+	if report.Bytes != 10 {
+		t.Errorf("expected 10 bytes, have %d", report.Bytes)
+	}
+
+	for _, key := range []string{"logs/a", "logs/b", "keep"} {
+		if ok, _ := s.Has(key); !ok {
+			t.Errorf("expected the preview to leave (%s) alone", key)
+		}
+	}
+}
+
+func TestStorageDryRunClear(t *testing.T) {
+	var logs bytes.Buffer
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		DryRun:            true,
+		Logger:            slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+
+	if _, err := s.WriteBytes("survivor", []byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := s.Has("survivor"); !ok {
+		t.Error("expected a dry-run Clear to keep the object")
+	}
+	_, fullPath, _ := s.paths("survivor")
+	if !strings.Contains(logs.String(), "would remove") || !strings.Contains(logs.String(), fullPath) {
+		t.Errorf("expected the object to be logged, have %q", logs.String())
+	}
+
+	s.DryRun = false
+	teardown(t, s)
+}
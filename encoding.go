@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+/* Compression selects how objects are compressed at rest. */
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+)
+
+/*
+Objects written by a store with an encoding configured start with an envelope
+header: 4 magic bytes, 1 flags byte and the 8 byte big-endian length of the
+original content, so Size can report it without decoding the body.
+*/
+const envelopeHeaderSize = 4 + 1 + 8
+
+const (
+	envelopeFlagGzip byte = 1 << iota
+	envelopeFlagEncrypted
+)
+
+var envelopeMagic = [4]byte{0x89, 'F', 'S', 'E'}
+
+/* encoded reports whether objects get an envelope and an encoded body. */
+func (store *DiskStore) encoded() bool {
+	return store.Compression != CompressionNone || store.aead != nil
+}
+
+/*
+encode copies r through w into file, wrapping it in the envelope and the
+configured encoders when the store has any. w is file or a writer wrapping it.
+It returns the number of bytes read from r.
+*/
+func (store *DiskStore) encode(ctx context.Context, file File, w io.Writer, r io.Reader) (int64, error) {
+	if !store.encoded() {
+		return copyContext(ctx, w, r)
+	}
+
+	// the header is patched in once the content length is known
+	if _, err := w.Write(make([]byte, envelopeHeaderSize)); err != nil {
+		return 0, err
+	}
+
+	var flags byte
+	var closers []io.Closer
+
+	// content is compressed first and then encrypted, ciphertext doesn't compress
+	if store.aead != nil {
+		ew, err := newEncryptWriter(w, store.aead)
+		if err != nil {
+			return 0, err
+		}
+		w, flags = ew, flags|envelopeFlagEncrypted
+		closers = append(closers, ew)
+	}
+
+	compress := store.Compression == CompressionGzip
+	if compress && store.CompressMinBytes > 0 {
+		var small bool
+		var err error
+		if r, small, err = peekSmall(r, store.CompressMinBytes); err != nil {
+			return 0, err
+		}
+		compress = !small
+	}
+
+	if compress {
+		gz := gzip.NewWriter(w)
+		w, flags = gz, flags|envelopeFlagGzip
+		closers = append(closers, gz)
+	}
+
+	n, err := copyContext(ctx, w, r)
+	if err != nil {
+		return 0, err
+	}
+
+	// close the innermost encoder first so it flushes into the next one
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := file.WriteAt(envelopeHeader(flags, n), 0); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+/*
+peekSmall reads up to threshold bytes of r to tell whether r holds fewer,
+returning a reader over all of r's content again.
+*/
+func peekSmall(r io.Reader, threshold int) (io.Reader, bool, error) {
+	buf := make([]byte, threshold)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return bytes.NewReader(buf[:n]), true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return io.MultiReader(bytes.NewReader(buf), r), false, nil
+}
+
+/*
+decode returns a reader over the original content of file. Files without an
+envelope are returned as is, so objects stored before an encoding was enabled
+stay readable. Closing the reader closes file.
+*/
+func (store *DiskStore) decode(file File) (io.ReadCloser, error) {
+	if !store.encoded() {
+		return file, nil
+	}
+
+	flags, _, ok, err := readEnvelopeHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !ok {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+
+	var r io.Reader = file
+	closers := []io.Closer{file}
+
+	if flags&envelopeFlagEncrypted != 0 {
+		if store.aead == nil {
+			file.Close()
+			return nil, fmt.Errorf("%w: object is encrypted but no EncryptionKey is configured", ErrDecryption)
+		}
+
+		dr, err := newDecryptReader(r, store.aead)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		r = dr
+	}
+
+	if flags&envelopeFlagGzip != 0 {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		r = gz
+		closers = append(closers, gz)
+	}
+
+	return &multiCloseReader{Reader: r, closers: closers}, nil
+}
+
+/*
+contentSize returns the length of the original content of the object at path,
+reading it from the envelope header when there is one.
+*/
+func (store *DiskStore) contentSize(path string, info os.FileInfo) (int64, error) {
+	if !store.encoded() {
+		return info.Size(), nil
+	}
+
+	file, err := store.openRetry(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	_, size, ok, err := readEnvelopeHeader(file)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return info.Size(), nil
+	}
+
+	return size, nil
+}
+
+/*
+decodeSeeker is a seekable view of an encoded object. Seeking only moves the
+position; the next Read decodes forward to it, starting the decoder over when
+the position lies behind what was already decoded.
+*/
+type decodeSeeker struct {
+	store *DiskStore
+	file  File
+	size  int64
+
+	r    io.ReadCloser
+	rpos int64
+	pos  int64
+}
+
+func (d *decodeSeeker) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+
+	if d.r == nil || d.pos < d.rpos {
+		if err := d.restart(); err != nil {
+			return 0, err
+		}
+	}
+	if d.pos > d.rpos {
+		n, err := io.CopyN(io.Discard, d.r, d.pos-d.rpos)
+		d.rpos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := d.r.Read(p)
+	d.rpos += int64(n)
+	d.pos = d.rpos
+	return n, err
+}
+
+func (d *decodeSeeker) restart() error {
+	if d.r != nil {
+		d.r.Close()
+		d.r = nil
+	}
+
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// the decoder must not close the file, it is reused by the next restart
+	r, err := d.store.decode(keepOpenFile{d.file})
+	if err != nil {
+		return err
+	}
+
+	d.r, d.rpos = r, 0
+	return nil
+}
+
+func (d *decodeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decodeSeeker) Close() error {
+	if d.r != nil {
+		d.r.Close()
+	}
+	return d.file.Close()
+}
+
+/* keepOpenFile is a File whose Close is a no-op. */
+type keepOpenFile struct {
+	File
+}
+
+func (keepOpenFile) Close() error { return nil }
+
+func envelopeHeader(flags byte, size int64) []byte {
+	header := make([]byte, envelopeHeaderSize)
+	copy(header, envelopeMagic[:])
+	header[4] = flags
+	binary.BigEndian.PutUint64(header[5:], uint64(size))
+	return header
+}
+
+/* readEnvelopeHeader reads the header from r; ok is false when r holds no envelope. */
+func readEnvelopeHeader(r io.Reader) (flags byte, size int64, ok bool, err error) {
+	header := make([]byte, envelopeHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, 0, false, nil
+		}
+		return 0, 0, false, err
+	}
+
+	if !bytes.Equal(header[:4], envelopeMagic[:]) {
+		return 0, 0, false, nil
+	}
+
+	return header[4], int64(binary.BigEndian.Uint64(header[5:])), true, nil
+}
+
+/* multiCloseReader reads from Reader and closes every closer, last one first. */
+type multiCloseReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *multiCloseReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if cerr := r.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
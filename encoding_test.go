@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStorageGzipCompression(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		Root:              "gziproot",
+		PathTransformFunc: CASPathTransformFunc,
+		Compression:       CompressionGzip,
+	})
+	defer teardown(t, s)
+
+	key := "compressed.json"
+	data := bytes.Repeat([]byte(`{"name":"onepiece","episode":1}`), 1000)
+	if _, err := s.Write(key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := s.ReadBytes(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("expected the decompressed content back, have %d bytes", len(b))
+	}
+
+	size, err := s.Size(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expected uncompressed size %d, have %d", len(data), size)
+	}
+
+	_, path, _ := s.paths(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= int64(len(data)) {
+		t.Errorf("expected the file on disk to be compressed, have %d bytes", info.Size())
+	}
+}
+
+func TestStorageCompressMinBytes(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Compression:       CompressionGzip,
+		CompressMinBytes:  100,
+	})
+	defer teardown(t, s)
+
+	small := []byte("tiny")
+	large := bytes.Repeat([]byte("compressible "), 100)
+	for key, data := range map[string][]byte{"small": small, "large": large} {
+		if _, err := s.Write(key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := s.ReadBytes(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Errorf("expected the content of %s back, have %q", key, b)
+		}
+		if size, _ := s.Size(key); size != int64(len(data)) {
+			t.Errorf("expected size %d for %s, have %d", len(data), key, size)
+		}
+	}
+
+	_, path, _ := s.paths("small")
+	if info, err := os.Stat(path); err != nil || info.Size() != envelopeHeaderSize+int64(len(small)) {
+		t.Errorf("expected the small object to be stored raw behind its header, have %v", err)
+	}
+
+	_, path, _ = s.paths("large")
+	if info, err := os.Stat(path); err != nil || info.Size() >= int64(len(large)) {
+		t.Errorf("expected the large object to be compressed, have %v", err)
+	}
+}
+
+func TestStorageReadDecompressed(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	content := []byte("some json payload")
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(content)
+	gz.Close()
+
+	for key, stored := range map[string][]byte{"gzipped": gzipped.Bytes(), "plain": content, "empty": {}} {
+		if _, err := s.Write(key, bytes.NewReader(stored)); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := s.ReadDecompressed(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		want := content
+		if key == "empty" {
+			want = []byte{}
+		}
+		if !bytes.Equal(b, want) {
+			t.Errorf("unexpected content of %s: %q", key, b)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Encrypted bodies start with a random per-object nonce prefix, followed by the
+content sealed with AES-256-GCM in chunks of encryptionChunkSize plaintext
+bytes. Each chunk's nonce is the prefix, a 4 byte chunk counter and a final
+chunk marker, so chunks can't be reordered, dropped or truncated unnoticed.
+The last chunk is always shorter than encryptionChunkSize and may be empty.
+*/
+const (
+	encryptionKeySize     = 32
+	encryptionChunkSize   = 64 * 1024
+	encryptionNoncePrefix = 7
+)
+
+/* ErrDecryption is returned when stored content fails authentication, e.g. a wrong key or tampering. */
+var ErrDecryption = errors.New("decryption failed")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, have %d", encryptionKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, encryptionNoncePrefix+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefix:], counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+/* encryptWriter seals everything written to it into w; Close writes the final chunk. */
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, aead cipher.AEAD) (*encryptWriter, error) {
+	prefix := make([]byte, encryptionNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	written := len(p)
+
+	for len(p) > 0 {
+		n := min(len(p), encryptionChunkSize-len(ew.buf))
+		ew.buf = append(ew.buf, p[:n]...)
+		p = p[n:]
+
+		if len(ew.buf) == encryptionChunkSize {
+			if err := ew.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+func (ew *encryptWriter) seal(final bool) error {
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.prefix, ew.counter, final), ew.buf, nil)
+	ew.counter++
+	ew.buf = ew.buf[:0]
+
+	_, err := ew.w.Write(sealed)
+	return err
+}
+
+/* decryptReader opens the chunks written by an encryptWriter. */
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	sealed  []byte
+	plain   []byte
+	done    bool
+}
+
+func newDecryptReader(r io.Reader, aead cipher.AEAD) (*decryptReader, error) {
+	prefix := make([]byte, encryptionNoncePrefix)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("%w: reading nonce: %w", ErrDecryption, err)
+	}
+
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		prefix: prefix,
+		sealed: make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+
+	return n, nil
+}
+
+func (dr *decryptReader) open() error {
+	n, err := io.ReadFull(dr.r, dr.sealed)
+
+	// only the final chunk is shorter than a full one
+	final := false
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		final = true
+	case err == io.EOF:
+		return fmt.Errorf("%w: missing final chunk", ErrDecryption)
+	case err != nil:
+		return err
+	}
+
+	plain, err := dr.aead.Open(dr.sealed[:0], chunkNonce(dr.prefix, dr.counter, final), dr.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %w", ErrDecryption, dr.counter, err)
+	}
+
+	dr.counter++
+	dr.plain = plain
+	dr.done = final
+
+	return nil
+}
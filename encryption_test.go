@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStorageEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, encryptionKeySize)
+	s := newStorageWithOptions(t, StorageOptions{
+		Root:              "encryptedroot",
+		PathTransformFunc: CASPathTransformFunc,
+		EncryptionKey:     key,
+		Compression:       CompressionGzip,
+	})
+	defer teardown(t, s)
+
+	payloads := map[string][]byte{
+		"empty":     {},
+		"small":     []byte("some jpg bytes"),
+		"chunkSize": bytes.Repeat([]byte("a"), 2*encryptionChunkSize),
+		"uneven":    bytes.Repeat([]byte("b"), encryptionChunkSize+123),
+	}
+
+	for name, data := range payloads {
+		if _, err := s.Write(name, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := s.ReadBytes(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Errorf("%s: expected the decrypted content back, have %d bytes", name, len(b))
+		}
+	}
+
+	_, path, _ := s.paths("small")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, payloads["small"]) {
+		t.Error("expected no plaintext on disk")
+	}
+
+	// flip a bit in the ciphertext
+	raw[len(raw)-1] ^= 1
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadBytes("small"); !errors.Is(err, ErrDecryption) {
+		t.Errorf("expected %v for tampered content, have %v", ErrDecryption, err)
+	}
+
+	wrongKey := newStorageWithOptions(t, StorageOptions{
+		Root:              s.Root,
+		PathTransformFunc: CASPathTransformFunc,
+		EncryptionKey:     bytes.Repeat([]byte{8}, encryptionKeySize),
+	})
+	if _, err := wrongKey.ReadBytes("uneven"); !errors.Is(err, ErrDecryption) {
+		t.Errorf("expected %v for the wrong key, have %v", ErrDecryption, err)
+	}
+}
+
+func TestNewStorageRejectsShortEncryptionKey(t *testing.T) {
+	_, err := NewStorage(StorageOptions{
+		Root:          "shortkeyroot",
+		EncryptionKey: []byte("too short"),
+	})
+	if err == nil {
+		os.RemoveAll("shortkeyroot")
+		t.Error("expected a short encryption key to be rejected")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ETag returns the HTTP entity tag of the object stored under key, quoted as it
+goes in the header. On a ContentAddressed store it is a strong tag, the key
+itself, since the key is the digest of the content. Other stores can't tell
+content apart without reading it, so theirs is a weak tag derived from the
+size and modification time, marked by the W/ prefix: it changes with every
+write, but two writes of different content of the same size within the
+timestamp resolution of the filesystem can share one. A missing key yields
+ErrKeyNotFound.
+*/
+func (store *DiskStore) ETag(key string) (string, error) {
+	info, err := store.Stat(key)
+	if err != nil {
+		return "", err
+	}
+
+	return store.etag(key, info), nil
+}
+
+/* etag is ETag for the object under key with info. */
+func (store *DiskStore) etag(key string, info os.FileInfo) string {
+	if store.ContentAddressed {
+		return `"` + key + `"`
+	}
+
+	return `W/"` + strconv.FormatInt(info.Size(), 36) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 36) + `"`
+}
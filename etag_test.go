@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStorageETag(t *testing.T) {
+	cas := newContentAddressedStorage(t)
+	defer teardown(t, cas)
+
+	key := writeContentAddressed(t, cas, []byte("tagged"))
+	if etag, _ := cas.ETag(key); etag != `"`+key+`"` {
+		t.Errorf("expected the digest as strong ETag, have %s", etag)
+	}
+
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("doc", bytes.NewReader([]byte("v1"))); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.ETag("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, `W/"`) {
+		t.Errorf("expected a weak ETag, have %s", first)
+	}
+
+	if _, err := s.Write("doc", bytes.NewReader([]byte("version 2"))); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := s.ETag("doc"); second == first {
+		t.Error("expected the ETag to change with the content")
+	}
+
+	if _, err := s.ETag("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageHandlerWeakETag(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("doc", bytes.NewReader([]byte("cached"))); err != nil {
+		t.Fatal(err)
+	}
+	etag, _ := s.ETag("doc")
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/doc", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status %d, have %d", http.StatusNotModified, resp.StatusCode)
+	}
+	if have := resp.Header.Get("ETag"); have != etag {
+		t.Errorf("expected ETag %s, have %s", etag, have)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+/*
+Evict removes the least recently used objects, ranked by modification time,
+until the store holds at most targetBytes, and returns the bytes freed. It
+walks the whole store to rank them. Objects being written or deleted
+meanwhile are skipped. Like GC it doesn't call OnDelete and removes objects
+outright, ignoring Trash and RefCounting.
+*/
+func (store *DiskStore) Evict(targetBytes int64) (int64, error) {
+	if err := store.writable(); err != nil {
+		return 0, err
+	}
+
+	return store.evict(targetBytes)
+}
+
+type evictCandidate struct {
+	path string
+	info os.FileInfo
+}
+
+func (store *DiskStore) evict(targetBytes int64) (int64, error) {
+	stats, err := store.Stats()
+	if err != nil || stats.TotalBytes <= targetBytes {
+		return 0, err
+	}
+
+	var candidates []evictCandidate
+	err = store.walkDir(store.Root, func(path string, info os.FileInfo) error {
+		candidates = append(candidates, evictCandidate{path: path, info: info})
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].info.ModTime().Before(candidates[j].info.ModTime())
+	})
+
+	var freed int64
+	for _, candidate := range candidates {
+		if stats.TotalBytes-freed <= targetBytes {
+			break
+		}
+
+		n, err := store.dropObject(candidate.path, nil)
+		freed += max(n, 0)
+		if err != nil {
+			return freed, err
+		}
+	}
+
+	return freed, nil
+}
+
+/*
+dropObject removes the object at path with everything kept alongside it and
+returns its size. Objects that are gone, whose key is locked or that drop,
+when not nil, rejects are left alone, returning -1.
+*/
+func (store *DiskStore) dropObject(path string, drop func(os.FileInfo) bool) (int64, error) {
+	unlock, ok := store.locks.tryLock(path)
+	if !ok {
+		return -1, nil
+	}
+	defer unlock()
+
+	// the object may have been replaced or deleted since it was chosen
+	info, err := store.FS.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	if drop != nil && !drop(info) {
+		return -1, nil
+	}
+
+	if err := store.FS.Remove(path); err != nil {
+		return -1, err
+	}
+	store.counters.removed(info)
+	store.checksums.forget(path)
+
+	if err := store.removeMeta(path); err != nil {
+		return info.Size(), err
+	}
+	if err := store.removeRefs(path); err != nil {
+		return info.Size(), err
+	}
+	if err := store.removeVersions(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return info.Size(), err
+	}
+
+	if store.PruneEmptyDirs {
+		store.pruneEmptyDirs(filepath.Dir(path))
+	}
+
+	return info.Size(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStorageEvict(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxBytes:          400,
+		EvictLRU:          true,
+	})
+	defer teardown(t, s)
+
+	// key0 is the least recently used, key3 the most
+	past := time.Now().Add(-time.Hour)
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if _, err := s.Write(key, bytes.NewReader(make([]byte, 100))); err != nil {
+			t.Fatal(err)
+		}
+		_, fullPath, _ := s.paths(key)
+		mtime := past.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(fullPath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Touch("key0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the write doesn't fit until key1, now the oldest, is gone
+	if _, err := s.Write("key4", bytes.NewReader(make([]byte, 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"key0": true, "key1": false, "key2": true, "key3": true, "key4": true} {
+		if ok, _ := s.Has(key); ok != want {
+			t.Errorf("expected Has(%s) to be %v", key, want)
+		}
+	}
+
+	freed, err := s.Evict(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != 200 {
+		t.Errorf("expected 200 bytes freed, have %d", freed)
+	}
+
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ObjectCount != 2 || stats.TotalBytes != 200 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if ok, _ := s.Has("key4"); !ok {
+		t.Error("expected the newest object to survive")
+	}
+}
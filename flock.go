@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+/*
+locksDirName is the directory below Root holding the lock files of a
+ProcessLock store: rootLockName, held shared by every process with the store
+open, and one file per lock stripe, held exclusively while a key of the stripe
+is modified. The files are never removed, so they can't be unlinked from under
+a process waiting on them.
+*/
+const (
+	locksDirName = ".locks"
+	rootLockName = "root"
+)
+
+/* errLocked is returned by lockFile for a lock held elsewhere when it must not wait. */
+var errLocked = errors.New("file is locked")
+
+func (store *DiskStore) locksRoot() string {
+	return filepath.Join(store.Root, locksDirName)
+}
+
+/*
+lockRoot takes the shared lock on the root lock file for the lifetime of the
+store. Failing to take it exclusively first means another process has the
+root open, which is logged as a warning since the per-key locks still keep
+the processes from corrupting each other's writes.
+*/
+func (store *DiskStore) lockRoot() error {
+	if err := os.MkdirAll(store.locksRoot(), store.DirMode); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(store.locksRoot(), rootLockName), os.O_RDWR|os.O_CREATE, store.FileMode)
+	if err != nil {
+		return err
+	}
+
+	err = lockFile(file, true, false)
+	if errors.Is(err, errLocked) {
+		store.Logger.Warn("storage root is already open in another process", "root", store.Root)
+	} else if err != nil {
+		file.Close()
+		return err
+	}
+
+	// downgrade to shared, so the next process to open the root sees this one
+	if err := lockFile(file, false, true); err != nil {
+		file.Close()
+		return err
+	}
+
+	store.rootLock = file
+	return nil
+}
+
+/*
+Close releases the root lock of a ProcessLock store, announcing to other
+processes that this one is done with the root, and closes the checksum index
+of a ChecksumIndex store. It's a no-op for other stores and for Namespace
+views.
+*/
+func (store *DiskStore) Close() error {
+	if len(store.prefix) > 0 {
+		return nil
+	}
+
+	err := store.checksums.close()
+	if store.rootLock == nil {
+		return err
+	}
+
+	err = errors.Join(err, store.rootLock.Close())
+	store.rootLock = nil
+	return err
+}
+
+/*
+lockStripeFile takes the exclusive lock on the file of stripe i in dir,
+waiting for it unless wait is false. The lock is gone once the file is closed.
+*/
+func lockStripeFile(dir string, i int, wait bool) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, strconv.Itoa(i)), os.O_RDWR|os.O_CREATE, defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file, true, wait); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
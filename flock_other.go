@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+/* lockFile fails: this platform has no advisory file locks. */
+func lockFile(file *os.File, exclusive, wait bool) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorageProcessLock(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	first := newStorageWithOptions(t, StorageOptions{
+		Root:        root,
+		ProcessLock: true,
+	})
+	defer first.Close()
+
+	// a second store stands in for another process, its locks are separate
+	var logs bytes.Buffer
+	second := newStorageWithOptions(t, StorageOptions{
+		Root:        root,
+		ProcessLock: true,
+		Logger:      slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	defer second.Close()
+
+	if !strings.Contains(logs.String(), "already open in another process") {
+		t.Errorf("expected a warning about the root being open, have %q", logs.String())
+	}
+
+	_, fullPathWithRoot, err := first.paths("picture")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock := first.locks.lock(fullPathWithRoot)
+	if _, ok := second.locks.tryLock(fullPathWithRoot); ok {
+		t.Error("expected the key to be locked by the other store")
+	}
+	unlock()
+
+	secondUnlock, ok := second.locks.tryLock(fullPathWithRoot)
+	if !ok {
+		t.Fatal("expected the key to be unlocked")
+	}
+	secondUnlock()
+
+	if _, err := second.Write("picture", bytes.NewReader([]byte("some jpg bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := first.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("expected the lock files to be left out of the keys, have %v", keys)
+	}
+
+	if _, err := first.Write(locksDirName, bytes.NewReader(nil)); err == nil {
+		t.Error("expected keys inside the locks directory to be rejected")
+	}
+}
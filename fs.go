@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+/*
+FS is the filesystem a DiskStore keeps its objects on. Its methods mirror
+those of afero.Fs, so an afero filesystem only needs a thin adapter, and tests
+can wrap OSFS to inject failures.
+*/
+type FS interface {
+	MkdirAll(path string, perm os.FileMode) error
+	Create(name string) (File, error)
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+/* File is an open file of an FS; *os.File satisfies it. */
+type File interface {
+	io.Reader
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Readdir(count int) ([]os.FileInfo, error)
+}
+
+/*
+Linker is implemented by filesystems that can hardlink files. Copy uses it to
+share the content of both keys and streams a copy on filesystems without it.
+*/
+type Linker interface {
+	Link(oldname, newname string) error
+}
+
+/* OSFS is the FS of the operating system, the default of every DiskStore. */
+type OSFS struct{}
+
+var (
+	_ FS     = OSFS{}
+	_ Linker = OSFS{}
+)
+
+func (OSFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+func (OSFS) Create(name string) (File, error) { return openFile(os.Create(name)) }
+
+func (OSFS) Open(name string) (File, error) { return openFile(os.Open(name)) }
+
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return openFile(os.OpenFile(name, flag, perm))
+}
+
+func (OSFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+func (OSFS) Rename(oldname, newname string) error { return os.Rename(oldname, newname) }
+
+func (OSFS) Remove(name string) error { return os.Remove(name) }
+
+func (OSFS) RemoveAll(path string) error { return os.RemoveAll(path) }
+
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (OSFS) Link(oldname, newname string) error { return os.Link(oldname, newname) }
+
+/* openFile keeps a failed open from turning into a non-nil File holding a nil *os.File. */
+func openFile(file *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+var errInjected = errors.New("injected failure")
+
+// faultyFS is the OS filesystem with failures injected into some calls.
+// Embedding the FS interface hides OSFS's Link, so Copy has to stream.
+type faultyFS struct {
+	FS
+	failRename bool
+	failStat   bool
+}
+
+func (f *faultyFS) Rename(oldname, newname string) error {
+	if f.failRename {
+		return errInjected
+	}
+	return f.FS.Rename(oldname, newname)
+}
+
+func (f *faultyFS) Stat(name string) (os.FileInfo, error) {
+	if f.failStat {
+		return nil, errInjected
+	}
+	return f.FS.Stat(name)
+}
+
+func TestStorageFSFaults(t *testing.T) {
+	fsys := &faultyFS{FS: OSFS{}}
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		FS:                fsys,
+	})
+	defer teardown(t, s)
+
+	fsys.failRename = true
+	if _, err := s.Write("picture", bytes.NewReader([]byte("some jpg bytes"))); !errors.Is(err, errInjected) {
+		t.Errorf("expected %v, have %v", errInjected, err)
+	}
+	fsys.failRename = false
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("expected the failed write to leave nothing behind, have %v", keys)
+	}
+
+	if _, err := s.Write("picture", bytes.NewReader([]byte("some jpg bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys.failStat = true
+	if ok, err := s.Has("picture"); ok || !errors.Is(err, errInjected) {
+		t.Errorf("expected (false, %v), have (%v, %v)", errInjected, ok, err)
+	}
+	fsys.failStat = false
+
+	// without a Linker Copy streams the content
+	if _, err := s.Copy("picture", "copied"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.ReadBytes("copied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "some jpg bytes" {
+		t.Errorf("unexpected copied content %q", b)
+	}
+}
+
+// syncFS counts the fsyncs of the files it opens.
+type syncFS struct {
+	FS
+	syncs int
+}
+
+func (f *syncFS) Open(name string) (File, error) {
+	file, err := f.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncFile{File: file, fs: f}, nil
+}
+
+func (f *syncFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &syncFile{File: file, fs: f}, nil
+}
+
+type syncFile struct {
+	File
+	fs *syncFS
+}
+
+func (f *syncFile) Sync() error {
+	f.fs.syncs++
+	return f.File.Sync()
+}
+
+func TestStorageSync(t *testing.T) {
+	fsys := &syncFS{FS: OSFS{}}
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		FS:                fsys,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("unsynced", bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.syncs != 0 {
+		t.Errorf("expected no fsyncs without Sync, have %d", fsys.syncs)
+	}
+
+	// the content before the rename and the directory after it
+	s.Sync = true
+	if _, err := s.Write("synced", bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.syncs != 2 {
+		t.Errorf("expected 2 fsyncs, have %d", fsys.syncs)
+	}
+}
+
+// crossDeviceFS fails renames out of dir like a rename across devices does.
+type crossDeviceFS struct {
+	FS
+	dir string
+}
+
+func (f *crossDeviceFS) Rename(oldname, newname string) error {
+	if strings.HasPrefix(oldname, f.dir+string(filepath.Separator)) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return f.FS.Rename(oldname, newname)
+}
+
+func TestStorageTempDirOnOtherDevice(t *testing.T) {
+	tempDir := t.TempDir()
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		TempDir:           tempDir,
+		FS:                &crossDeviceFS{FS: OSFS{}, dir: tempDir},
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("picture", bytes.NewReader([]byte("some jpg bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := s.ReadBytes("picture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "some jpg bytes" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the temp dir to be empty, have %d entries", len(entries))
+	}
+
+	pathKey := s.PathTransformFunc("picture")
+	entries, err = os.ReadDir(filepath.Join(s.Root, pathKey.Pathname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the object file, have %d entries", len(entries))
+	}
+}
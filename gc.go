@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* defaultGCGracePeriod is how old temp files and empty directories must be before GC removes them. */
+const defaultGCGracePeriod = time.Hour
+
+/* GCReport counts what a GC run reclaimed. */
+type GCReport struct {
+	/* TempFiles are leftovers of writes and uploads that never finished, e.g. after a crash. */
+	TempFiles int
+
+	/*
+		Orphans are sidecars and kept revisions whose object is gone, and
+		Dedup index entries of content no key holds anymore.
+	*/
+	Orphans int
+
+	EmptyDirs int
+
+	/* Bytes is the size of all files removed. */
+	Bytes int64
+}
+
+/*
+GC removes what crashed writes and deletes leave behind: temp files, files of
+abandoned uploads and empty directories older than GCGracePeriod and sidecars
+of missing objects. It is safe to run alongside other operations; objects
+locked by one are skipped and left for the next run. The trash is left alone,
+EmptyTrash purges it. When dry running, the report counts what would be
+removed.
+*/
+func (store *DiskStore) GC() (GCReport, error) {
+	if err := store.writable(); err != nil {
+		return GCReport{}, err
+	}
+
+	var report GCReport
+
+	_, err := store.gcDir(filepath.Join(store.Root, store.prefix), time.Now(), &report)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	// the index is shared by all namespaces
+	if err == nil && len(store.prefix) == 0 {
+		err = store.gcDedup(&report)
+	}
+
+	return report, err
+}
+
+/* gcDir collects garbage in dir and reports whether dir is empty afterwards. */
+func (store *DiskStore) gcDir(dir string, now time.Time, report *GCReport) (bool, error) {
+	d, err := store.FS.Open(dir)
+	if err != nil {
+		return false, err
+	}
+
+	infos, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return false, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	remaining := 0
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+
+		var removed bool
+		switch {
+		case store.reservedDir(path):
+		case info.IsDir():
+			empty, err := store.gcDir(path, now, report)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			// the age is from before gcDir emptied it; younger dirs may be about to get a write
+			if empty && now.Sub(info.ModTime()) > store.GCGracePeriod && store.removeFile(path, 0) == nil {
+				report.EmptyDirs++
+				removed = true
+			}
+		case isTempFile(info.Name()), isUploadFile(info.Name()):
+			if now.Sub(info.ModTime()) > store.GCGracePeriod && store.removeFile(path, info.Size()) == nil {
+				report.TempFiles++
+				report.Bytes += info.Size()
+				removed = true
+			}
+		case !isObjectFile(info.Name()):
+			removed, err = store.gcOrphan(path, info, report)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if !removed {
+			remaining++
+		}
+	}
+
+	return remaining == 0, nil
+}
+
+/* gcOrphan removes the sidecar or revision at path when its object is gone. */
+func (store *DiskStore) gcOrphan(path string, info os.FileInfo, report *GCReport) (bool, error) {
+	object := sidecarObject(path)
+
+	unlock, ok := store.locks.tryLock(object)
+	if !ok {
+		return false, nil
+	}
+	defer unlock()
+
+	if _, err := store.FS.Stat(object); !errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err := store.removeFile(path, info.Size()); err != nil {
+		return false, err
+	}
+
+	report.Orphans++
+	report.Bytes += info.Size()
+
+	return true, nil
+}
+
+/* sidecarObject returns the path of the object the sidecar or revision at path belongs to. */
+func sidecarObject(path string) string {
+	if filename, _, ok := parseVersionFile(filepath.Base(path)); ok {
+		return filepath.Join(filepath.Dir(path), filename)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(path, metaFileSuffix), refsFileSuffix)
+}
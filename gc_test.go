@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorageGC(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		GCGracePeriod:     time.Minute,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("kept", bytes.NewReader([]byte("kept"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// an object removed behind the store's back leaves its sidecar orphaned
+	if _, err := s.WriteWithMeta("vanished", bytes.NewReader([]byte("x")), map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	_, vanished, _ := s.paths("vanished")
+	if err := os.Remove(vanished); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+
+	staleDir := filepath.Join(s.Root, "stale", "nested")
+	if err := os.MkdirAll(staleDir, s.DirMode); err != nil {
+		t.Fatal(err)
+	}
+	staleTemp := filepath.Join(staleDir, ".crashed.abc.tmp")
+	if err := os.WriteFile(staleTemp, []byte("partial"), s.FileMode); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{staleTemp, staleDir, filepath.Dir(staleDir)} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a young temp file may belong to a write in flight
+	freshTemp := filepath.Join(s.Root, ".inflight.abc.tmp")
+	if err := os.WriteFile(freshTemp, []byte("partial"), s.FileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TempFiles != 1 || report.Orphans != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.EmptyDirs < 2 {
+		t.Errorf("expected the empty directories to be removed, have %+v", report)
+	}
+	if report.Bytes != int64(len("partial"))+int64(len(`{"a":"b"}`)) {
+		t.Errorf("unexpected reclaimed bytes %d", report.Bytes)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.Root, "stale")); !os.IsNotExist(err) {
+		t.Errorf("expected the stale directories to be gone, have %v", err)
+	}
+	if _, err := os.Stat(freshTemp); err != nil {
+		t.Errorf("expected the fresh temp file to survive, have %v", err)
+	}
+	if b, _ := s.ReadBytes("kept"); string(b) != "kept" {
+		t.Errorf("expected the object to survive, have %q", b)
+	}
+}
+
+func TestStorageGCKeepsKeysNamedLikeSidecars(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{Root: "gcroot", GCGracePeriod: time.Minute})
+	defer teardown(t, s)
+
+	for _, key := range []string{"a.meta", "b.v2", "c.refs", ".d.abc.tmp", "e.partial", "f.offset"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("write %q: expected %v, have %v", key, ErrInvalidKey, err)
+		}
+	}
+	for _, key := range []string{"a", "b.v", "c.v0", "d.tmp"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte("x"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Now().Add(-time.Hour)
+	if err := filepath.Walk(s.Root, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (GCReport{}) {
+		t.Errorf("expected GC to find nothing to remove, have %+v", report)
+	}
+	if keys, _ := s.Keys(); len(keys) != 4 {
+		t.Errorf("expected all keys to survive, have %v", keys)
+	}
+}
+
+func TestStorageGCAbandonedUploads(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		GCGracePeriod:     time.Minute,
+	})
+	defer teardown(t, s)
+
+	for _, key := range []string{"abandoned", "inflight"} {
+		rw, err := s.ResumableWriter(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rw.Write([]byte("part")); err != nil {
+			t.Fatal(err)
+		}
+		rw.file.Close()
+	}
+
+	old := time.Now().Add(-time.Hour)
+	_, abandoned, _ := s.paths("abandoned")
+	for _, path := range []string{abandoned + partialFileSuffix, abandoned + offsetFileSuffix} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TempFiles != 2 || report.Orphans != 0 {
+		t.Errorf("expected both files of the abandoned upload to be removed, have %+v", report)
+	}
+	if offset, _ := s.Offset("abandoned"); offset != 0 {
+		t.Errorf("expected the abandoned upload to be gone, have offset %d", offset)
+	}
+	if offset, _ := s.Offset("inflight"); offset != 4 {
+		t.Errorf("expected the upload in flight to survive, have offset %d", offset)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+/*
+Handler returns an http.Handler serving the object whose key is the request
+path without its leading slash; mount it with http.StripPrefix below a
+prefix. Responses carry Content-Length, Last-Modified and a Content-Type, the
+one in the object's metadata, e.g. recorded by DetectContentType, or else one
+sniffed now. They carry the ETag of the object, and honor conditional and
+range requests.
+*/
+func (store *DiskStore) Handler() http.Handler {
+	return http.HandlerFunc(store.serveHTTP)
+}
+
+func (store *DiskStore) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if len(key) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	// read before the object is opened, each takes a MaxConcurrentOps slot
+	var meta map[string]string
+	_, fullPathWithRoot, err := store.paths(key)
+	if err == nil {
+		meta, _ = store.readMeta(fullPathWithRoot)
+	}
+
+	file, info, err := store.Open(key)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrInvalidKey):
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	case err != nil:
+		store.Logger.Error("serving object failed", "key", key, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// ServeContent answers If-None-Match with 304 Not Modified, weak tags included
+	w.Header().Set("ETag", store.etag(key, info))
+
+	// the type sniffed on write, otherwise the empty name makes ServeContent sniff it now
+	if len(meta[ContentTypeMetaKey]) > 0 {
+		w.Header().Set("Content-Type", meta[ContentTypeMetaKey])
+	}
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestStorageHandler(t *testing.T) {
+	s := newContentAddressedStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("hello, plain text")
+	key := writeContentAddressed(t, s, data)
+
+	srv := httptest.NewServer(http.StripPrefix("/blobs", s.Handler()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/blobs/" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, have %d", http.StatusOK, resp.StatusCode)
+	}
+	if have := resp.Header.Get("Content-Length"); have != strconv.Itoa(len(data)) {
+		t.Errorf("expected Content-Length %d, have %s", len(data), have)
+	}
+	if have := resp.Header.Get("Content-Type"); have != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %s", have)
+	}
+	if have := resp.Header.Get("ETag"); have != `"`+key+`"` {
+		t.Errorf("unexpected ETag %s", have)
+	}
+	if len(resp.Header.Get("Last-Modified")) == 0 {
+		t.Error("expected a Last-Modified header")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/blobs/"+key, nil)
+	req.Header.Set("If-None-Match", `"`+key+`"`)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status %d, have %d", http.StatusNotModified, resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/blobs/missing_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d, have %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/blobs/"+key, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, have %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+inPlace checks that the objects of the store may be modified in place: their
+files have to hold the plain content, and content-addressed keys would stop
+matching it.
+*/
+func (store *DiskStore) inPlace() error {
+	if err := store.writable(); err != nil {
+		return err
+	}
+	if store.encoded() {
+		return fmt.Errorf("%w: modifying compressed or encrypted objects in place", errors.ErrUnsupported)
+	}
+	if store.ContentAddressed {
+		return fmt.Errorf("%w: modifying content-addressed objects in place", errors.ErrUnsupported)
+	}
+	if store.deduplicating() {
+		return fmt.Errorf("%w: modifying deduplicated objects in place", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+/*
+unshare gives the object at fullPathWithRoot a file of its own when it shares
+one with other keys, as Copy leaves it by hardlinking, so changes in place
+don't reach them: the content is copied to a temp file renamed over the
+object. Where the link count is unknown, an FS that can link always gets the
+copy. The caller holds the lock of fullPathWithRoot.
+*/
+func (store *DiskStore) unshare(pathnameWithRoot, fullPathWithRoot string) error {
+	if _, ok := store.FS.(Linker); !ok {
+		return nil
+	}
+
+	info, err := store.FS.Stat(fullPathWithRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n, ok := linkCount(info); ok && n <= 1 {
+		return nil
+	}
+
+	file, err := store.openRetry(fullPathWithRoot)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := store.writeFile(pathnameWithRoot, fullPathWithRoot, file); err != nil {
+		return err
+	}
+	store.statCache.remove(fullPathWithRoot)
+
+	return nil
+}
+
+/*
+OpenReadWrite opens the file of the object stored under key for reading and
+writing, positioned at its start, creating an empty object if key is new.
+
+It is meant for advanced uses like patching a header and bypasses the
+guarantees of Write: changes are visible to readers as they are made, the
+key isn't locked against concurrent writes, and previous versions, quotas
+and references are not maintained. An object sharing its file with a Copy
+gets a file of its own first, so other keys never change. Has, Size and Stats reflect the changes
+once the handle is closed, which also calls OnWrite. Stores with
+Compression, an EncryptionKey or ContentAddressed fail with
+errors.ErrUnsupported.
+*/
+func (store *DiskStore) OpenReadWrite(key string) (File, error) {
+	if err := store.inPlace(); err != nil {
+		return nil, err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.FS.MkdirAll(pathnameWithRoot, store.DirMode); err != nil {
+		return nil, err
+	}
+
+	unlock := store.locks.lock(fullPathWithRoot)
+	err = store.unshare(pathnameWithRoot, fullPathWithRoot)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := store.FS.OpenFile(fullPathWithRoot, os.O_RDWR|os.O_CREATE, store.FileMode)
+	if err != nil {
+		return nil, err
+	}
+	store.bloom.add(fullPathWithRoot)
+	store.checksums.forget(fullPathWithRoot)
+
+	return &inPlaceFile{File: file, store: store, key: key}, nil
+}
+
+/* inPlaceFile accounts for the changes made through it once it is closed. */
+type inPlaceFile struct {
+	File
+	store *DiskStore
+	key   string
+}
+
+func (f *inPlaceFile) Close() error {
+	// the size may have changed in any way, so the next Stats walks the store
+	f.store.counters.invalidate()
+	f.store.statCache.remove(f.File.Name())
+
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	if info, err := f.store.FS.Stat(f.File.Name()); err == nil {
+		f.store.wrote(f.key, info.Size())
+	}
+
+	return nil
+}
+
+/*
+Append adds the content of r to the end of the object stored under key,
+creating it if key is new, and returns the number of bytes appended. Unlike
+Write it is not atomic: readers may observe a partial append, and a failing
+one leaves what it appended so far behind. An object sharing its file with a
+Copy gets a file of its own first, so other keys never change. Stores with Compression, an
+EncryptionKey or ContentAddressed fail with errors.ErrUnsupported, since the
+appended file would no longer be valid or match its key.
+*/
+func (store *DiskStore) Append(key string, r io.Reader) (int64, error) {
+	if err := store.inPlace(); err != nil {
+		return 0, err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	unlock := store.locks.lock(fullPathWithRoot)
+	n, size, err := store.appendObject(pathnameWithRoot, fullPathWithRoot, r)
+	unlock()
+
+	store.Metrics.ObserveWrite(n, time.Since(start), err)
+	if err != nil {
+		return n, err
+	}
+	store.wrote(key, size)
+
+	return n, nil
+}
+
+/* appendObject appends r to the object at fullPathWithRoot, returning the bytes appended and its new size. */
+func (store *DiskStore) appendObject(pathnameWithRoot, fullPathWithRoot string, r io.Reader) (int64, int64, error) {
+	if err := store.FS.MkdirAll(pathnameWithRoot, store.DirMode); err != nil {
+		return 0, 0, err
+	}
+
+	if err := store.unshare(pathnameWithRoot, fullPathWithRoot); err != nil {
+		return 0, 0, err
+	}
+
+	old, _ := store.FS.Stat(fullPathWithRoot)
+
+	file, err := store.FS.OpenFile(fullPathWithRoot, os.O_APPEND|os.O_CREATE|os.O_WRONLY, store.FileMode)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	length := lengthOf(r)
+	if p := store.newProgress(length); p != nil {
+		r = &progressReader{r: r, p: p}
+	}
+
+	if store.MaxObjectBytes > 0 {
+		remaining := store.MaxObjectBytes
+		if old != nil {
+			remaining -= old.Size()
+		}
+		r = &maxSizeReader{r: r, remaining: max(remaining, 0)}
+	}
+
+	var w io.Writer = file
+	if store.MaxBytes > 0 {
+		qw, err := store.newQuotaWriter(file, fullPathWithRoot, length)
+		if err != nil {
+			file.Close()
+			return 0, 0, err
+		}
+		defer qw.release()
+		// the existing content stays, so none of it counts as free
+		qw.credit = 0
+		w = qw
+	}
+
+	n, err := io.Copy(w, r)
+	if err == nil && store.Sync {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	info, serr := store.FS.Stat(fullPathWithRoot)
+	if serr != nil {
+		store.counters.invalidate()
+		return n, 0, errors.Join(err, serr)
+	}
+	store.counters.replaced(old, info.Size())
+	store.bloom.add(fullPathWithRoot)
+	store.checksums.forget(fullPathWithRoot)
+
+	if err == nil && old == nil {
+		err = store.syncDir(pathnameWithRoot)
+	}
+
+	return n, info.Size(), err
+}
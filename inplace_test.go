@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStorageOpenReadWrite(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("patched", bytes.NewReader([]byte("HEADER body"))); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := s.OpenReadWrite("patched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(file, header); err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "HEADER" {
+		t.Errorf("expected to read from the start, have %q", header)
+	}
+	if _, err := file.WriteAt([]byte("header"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(" and more")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := s.ReadBytes("patched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "header body and more" {
+		t.Errorf("unexpected patched content %q", b)
+	}
+	if size, _ := s.Size("patched"); size != int64(len(b)) {
+		t.Errorf("expected Size %d, have %d", len(b), size)
+	}
+	if stats, _ := s.Stats(); stats.TotalBytes != int64(len(b)) {
+		t.Errorf("expected Stats to account for the patch, have %+v", stats)
+	}
+
+	// a new key starts out empty
+	file, err = s.OpenReadWrite("nested/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if ok, _ := s.Has("nested/new"); !ok {
+		t.Error("expected the new key to be stored")
+	}
+
+	s.Compression = CompressionGzip
+	if _, err := s.OpenReadWrite("patched"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected %v for a compressed store, have %v", errors.ErrUnsupported, err)
+	}
+}
+
+func TestStorageAppend(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{MaxObjectBytes: 16})
+	defer teardown(t, s)
+
+	for _, line := range []string{"one\n", "two\n"} {
+		n, err := s.Append("log", bytes.NewReader([]byte(line)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(line)) {
+			t.Errorf("expected %d bytes appended, have %d", len(line), n)
+		}
+	}
+
+	b, err := s.ReadBytes("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "one\ntwo\n" {
+		t.Errorf("unexpected content %q", b)
+	}
+	if stats, _ := s.Stats(); stats.ObjectCount != 1 || stats.TotalBytes != int64(len(b)) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if _, err := s.Append("log", bytes.NewReader([]byte("way too long"))); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("expected %v, have %v", ErrObjectTooLarge, err)
+	}
+
+	s.ContentAddressed = true
+	if _, err := s.Append("log", bytes.NewReader([]byte("more"))); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected %v for a content-addressed store, have %v", errors.ErrUnsupported, err)
+	}
+}
+
+func TestStorageOpenReadWriteAfterCopy(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("src", bytes.NewReader([]byte("hello world"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Copy("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := s.OpenReadWrite("dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("J"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := s.ReadBytes("dst"); string(b) != "Jello world" {
+		t.Errorf("expected the patched copy, have %q", b)
+	}
+	if b, _ := s.ReadBytes("src"); string(b) != "hello world" {
+		t.Errorf("expected the source of the copy unchanged, have %q", b)
+	}
+}
+
+func TestStorageAppendAfterCopy(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("src", bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Copy("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Append("dst", bytes.NewReader([]byte(" world"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := s.ReadBytes("dst"); string(b) != "hello world" {
+		t.Errorf("expected the appended copy, have %q", b)
+	}
+	if b, _ := s.ReadBytes("src"); string(b) != "hello" {
+		t.Errorf("expected the source of the copy unchanged, have %q", b)
+	}
+}
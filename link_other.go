@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+/* linkCount is unknown on platforms whose FileInfo doesn't carry it. */
+func linkCount(os.FileInfo) (uint64, bool) {
+	return 0, false
+}
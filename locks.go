@@ -0,0 +1,105 @@
+package main
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+/* lockStripes is the number of mutexes keys are spread over. */
+const lockStripes = 256
+
+/*
+keyLocks serializes operations on the same object path. Paths are hashed onto a
+fixed set of mutexes, so two distinct keys may share a stripe and briefly wait
+on each other, but memory stays bounded no matter how many keys there are.
+
+With cache set, releasing the lock of a path drops its cached lookup, as the
+operation holding it may have changed the object.
+
+With dir set, every stripe is also backed by an advisory lock on a file in dir,
+serializing the operations of other processes sharing the store. A stripe file
+that can't be locked is logged, leaving the stripe locked in this process only.
+*/
+type keyLocks struct {
+	stripes [lockStripes]sync.Mutex
+
+	cache *statCache
+
+	dir    string
+	logger *slog.Logger
+}
+
+/*
+lock acquires the stripes of all paths and returns the function releasing them.
+Stripes are always taken in ascending order so concurrent callers can't deadlock.
+*/
+func (l *keyLocks) lock(paths ...string) (unlock func()) {
+	indexes := stripeIndexes(paths)
+
+	var files []*os.File
+	for _, i := range indexes {
+		l.stripes[i].Lock()
+
+		if len(l.dir) > 0 {
+			file, err := lockStripeFile(l.dir, i, true)
+			if err != nil {
+				l.logger.Error("locking stripe file failed", "dir", l.dir, "stripe", i, "err", err)
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+
+	return func() {
+		l.cache.remove(paths...)
+		for _, file := range files {
+			file.Close()
+		}
+		for i := len(indexes) - 1; i >= 0; i-- {
+			l.stripes[indexes[i]].Unlock()
+		}
+	}
+}
+
+/* tryLock is like lock for a single path but gives up instead of waiting when it is held. */
+func (l *keyLocks) tryLock(path string) (unlock func(), ok bool) {
+	i := stripeIndexes([]string{path})[0]
+	if !l.stripes[i].TryLock() {
+		return nil, false
+	}
+
+	if len(l.dir) == 0 {
+		return func() {
+			l.cache.remove(path)
+			l.stripes[i].Unlock()
+		}, true
+	}
+
+	file, err := lockStripeFile(l.dir, i, false)
+	if err != nil {
+		l.stripes[i].Unlock()
+		return nil, false
+	}
+
+	return func() {
+		l.cache.remove(path)
+		file.Close()
+		l.stripes[i].Unlock()
+	}, true
+}
+
+/* stripeIndexes returns the sorted, distinct stripes of paths. */
+func stripeIndexes(paths []string) []int {
+	indexes := make([]int, 0, len(paths))
+	for _, path := range paths {
+		h := fnv.New32a()
+		h.Write([]byte(path))
+		indexes = append(indexes, int(h.Sum32()%lockStripes))
+	}
+
+	slices.Sort(indexes)
+	return slices.Compact(indexes)
+}
@@ -26,7 +26,10 @@ func makeServer(listenAddr string, nodes ...string) *FileServer {
 		BootstrapNodes:    nodes,
 	}
 
-	s := NewFileServer(fileServerOptions)
+	s, err := NewFileServer(fileServerOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	tcpTransport.OnPeer = s.OnPeer
 
@@ -49,7 +52,7 @@ func main() {
 
 	for i := 0; i < 10; i++ {
 		data := bytes.NewReader([]byte("my big data file here!"))
-		s2.Store(fmt.Sprintf("myprivatedata_d%", i), data)
+		s2.Store(fmt.Sprintf("myprivatedata_%d", i), data)
 		time.Sleep(time.Millisecond * 500)
 	}
 	// r, err := s2.Get("myprivatedata")
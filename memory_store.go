@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+/*
+MemoryStore is a Store keeping objects in memory, meant for fast hermetic
+tests of code that depends on a Store. Keys go through the same
+PathTransformFunc as on disk so both stores agree on key identity.
+*/
+type MemoryStore struct {
+	PathTransformFunc PathTransformFunc
+
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore(pathTransformFunc PathTransformFunc) *MemoryStore {
+	if pathTransformFunc == nil {
+		pathTransformFunc = DefaultPathTransformFunc
+	}
+
+	return &MemoryStore{
+		PathTransformFunc: pathTransformFunc,
+		objects:           make(map[string][]byte),
+	}
+}
+
+func (store *MemoryStore) path(key string) (string, error) {
+	pathKey := store.PathTransformFunc(key)
+	if len(pathKey.Filename) == 0 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return pathKey.FullPath(), nil
+}
+
+func (store *MemoryStore) Has(key string) (bool, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	_, ok := store.objects[path]
+	return ok, nil
+}
+
+func (store *MemoryStore) Size(key string) (int64, error) {
+	data, err := store.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+/* Read returns a reader over a copy of the content, so later writes can't change it. */
+func (store *MemoryStore) Read(key string) (io.ReadCloser, error) {
+	data, err := store.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(bytes.Clone(data))), nil
+}
+
+func (store *MemoryStore) get(key string) ([]byte, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	data, ok := store.objects[path]
+	if !ok {
+		return nil, fmt.Errorf("%w (%s)", ErrKeyNotFound, key)
+	}
+	return data, nil
+}
+
+func (store *MemoryStore) Write(key string, r io.Reader) (int64, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.objects[path] = data
+	return int64(len(data)), nil
+}
+
+func (store *MemoryStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.objects, path)
+	return nil
+}
+
+/* Keys returns the Filename of every stored object, like DiskStore.Keys. */
+func (store *MemoryStore) Keys() ([]string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	keys := make([]string, 0, len(store.objects))
+	for path := range store.objects {
+		keys = append(keys, filepath.Base(path))
+	}
+	// sorted like the listings of the other stores
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (store *MemoryStore) Clear() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.objects = make(map[string][]byte)
+	return nil
+}
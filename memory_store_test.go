@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(CASPathTransformFunc)
+
+	data := []byte("some jpg bytes")
+	if _, err := s.Write("picture", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := s.Has("picture"); err != nil || !ok {
+		t.Errorf("expected to have picture (%v)", err)
+	}
+
+	r, err := s.Read("picture")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reader must not alias the stored bytes
+	if _, err := s.Write("picture", bytes.NewReader([]byte("changed"))); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); !bytes.Equal(b, data) {
+		t.Errorf("expected %s have %s", data, b)
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != CASPathTransformFunc("picture").Filename {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if err := s.Delete("picture"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read("picture"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestMemoryStoreKeysSorted(t *testing.T) {
+	s := NewMemoryStore(DefaultPathTransformFunc)
+
+	for _, key := range []string{"c", "a", "d", "b"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sort.StringsAreSorted(keys) || len(keys) != 4 {
+		t.Errorf("expected the keys in order, have %v", keys)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+Metadata lives in a JSON sidecar file next to its object, named after the
+object's file with metaFileSuffix appended. Sidecars are neither compressed
+nor encrypted and don't count towards Stats or MaxBytes.
+*/
+const metaFileSuffix = ".meta"
+
+/* isMetaFile reports whether name is the metadata sidecar of an object. */
+func isMetaFile(name string) bool {
+	return strings.HasSuffix(name, metaFileSuffix)
+}
+
+func metaPath(fullPathWithRoot string) string {
+	return fullPathWithRoot + metaFileSuffix
+}
+
+/*
+WriteWithMeta is like Write but also stores meta alongside the object,
+replacing any metadata key had before.
+*/
+func (store *DiskStore) WriteWithMeta(key string, r io.Reader, meta map[string]string) (int64, error) {
+	if err := store.writable(); err != nil {
+		return 0, err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	unlock := store.locks.lock(fullPathWithRoot)
+	result, err := store.writeObject(context.Background(), pathnameWithRoot, fullPathWithRoot, r)
+	if err == nil {
+		var b []byte
+		if b, err = json.Marshal(withContentType(meta, result.ContentType)); err == nil {
+			err = store.writeMeta(pathnameWithRoot, fullPathWithRoot, b)
+		}
+	}
+	unlock()
+
+	store.Metrics.ObserveWrite(result.Bytes, time.Since(start), err)
+
+	if err != nil {
+		return 0, err
+	}
+	store.wrote(key, result.Bytes)
+
+	return result.Bytes, nil
+}
+
+/*
+ReadMeta returns the metadata stored with key, which is empty for objects
+written without any. A missing key yields ErrKeyNotFound.
+*/
+func (store *DiskStore) ReadMeta(key string) (map[string]string, error) {
+	if _, err := store.stat(key); err != nil {
+		return nil, err
+	}
+
+	_, fullPathWithRoot, _ := store.paths(key)
+
+	meta, err := store.readMeta(fullPathWithRoot)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		meta = map[string]string{}
+	}
+
+	return meta, nil
+}
+
+/*
+Find returns the Filename, as Keys reports it, of every object whose metadata
+maps tag to value. It reads the sidecar of every object, so its cost grows
+with the size of the store.
+*/
+func (store *DiskStore) Find(tag, value string) ([]string, error) {
+	keys := []string{}
+	err := store.walkDir(filepath.Join(store.Root, store.prefix), func(objectPath string, info os.FileInfo) error {
+		meta, err := store.readMeta(objectPath)
+		if err != nil {
+			return err
+		}
+
+		if v, ok := meta[tag]; ok && v == value {
+			keys = append(keys, store.filenameOf(info.Name()))
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+
+	return keys, err
+}
+
+/* readMeta decodes the sidecar of the object at fullPathWithRoot; nil when there is none. */
+func (store *DiskStore) readMeta(fullPathWithRoot string) (map[string]string, error) {
+	file, err := store.openRetry(metaPath(fullPathWithRoot))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var meta map[string]string
+	if err := json.NewDecoder(file).Decode(&meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+/* writeMeta atomically replaces the sidecar of the object at fullPathWithRoot with b. */
+func (store *DiskStore) writeMeta(pathnameWithRoot, fullPathWithRoot string, b []byte) error {
+	return store.writeSidecar(pathnameWithRoot, metaPath(fullPathWithRoot), b)
+}
+
+/* writeSidecar atomically replaces the sidecar file at path in dir with b. */
+func (store *DiskStore) writeSidecar(dir, path string, b []byte) error {
+	return store.writeFile(dir, path, bytes.NewReader(b))
+}
+
+/*
+copyMeta gives the object at dstPath the metadata of the one at srcPath,
+removing a stale sidecar of dstPath when srcPath has none.
+*/
+func (store *DiskStore) copyMeta(srcPath, dstDir, dstPath string) error {
+	file, err := store.openRetry(metaPath(srcPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return store.removeMeta(dstPath)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	return store.writeMeta(dstDir, dstPath, b)
+}
+
+/* removeMeta removes the sidecar of the object at fullPathWithRoot, if any. */
+func (store *DiskStore) removeMeta(fullPathWithRoot string) error {
+	if err := store.FS.Remove(metaPath(fullPathWithRoot)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestStorageMeta(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	meta := map[string]string{"contentType": "image/png", "filename": "logo.png"}
+	if _, err := s.WriteWithMeta("logo", bytes.NewReader([]byte("png bytes")), meta); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := s.ReadMeta("logo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != 2 || have["contentType"] != "image/png" || have["filename"] != "logo.png" {
+		t.Errorf("unexpected metadata %v", have)
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("expected the sidecar to not be listed, have %v", keys)
+	}
+
+	if err := s.Move("logo", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := s.ReadMeta("moved"); have["filename"] != "logo.png" {
+		t.Errorf("expected the metadata to move along, have %v", have)
+	}
+
+	if err := s.Delete("moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadMeta("moved"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+
+	// the sidecar went with the object, a new object starts without metadata
+	if _, err := s.Write("moved", bytes.NewReader([]byte("other"))); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := s.ReadMeta("moved"); err != nil || len(have) != 0 {
+		t.Errorf("expected no metadata, have %v (%v)", have, err)
+	}
+}
+
+func TestStorageFind(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	objects := map[string]map[string]string{
+		"logo":   {"contentType": "image/png"},
+		"banner": {"contentType": "image/png", "campaign": "spring"},
+		"readme": {"contentType": "text/plain"},
+	}
+	for key, meta := range objects {
+		if _, err := s.WriteWithMeta(key, bytes.NewReader([]byte(key)), meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Write("untagged", bytes.NewReader([]byte("untagged"))); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := s.Find("contentType", "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		s.PathTransformFunc("logo").Filename:   true,
+		s.PathTransformFunc("banner").Filename: true,
+	}
+	if len(keys) != len(want) {
+		t.Errorf("expected %d keys, have %v", len(want), keys)
+	}
+	for _, key := range keys {
+		if !want[key] {
+			t.Errorf("unexpected key %s", key)
+		}
+	}
+
+	if keys, _ := s.Find("campaign", "autumn"); len(keys) != 0 {
+		t.Errorf("expected no matches, have %v", keys)
+	}
+}
+
+func TestStorageRejectsSidecarKeys(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{Root: "sidecarroot"})
+	defer teardown(t, s)
+
+	for _, key := range []string{"a.meta", "docs/a.meta", "report.v2", "c.refs"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("write %q: expected %v, have %v", key, ErrInvalidKey, err)
+		}
+	}
+
+	// a transform hashing keys never produces such a name
+	hashed := newStorage(t)
+	defer teardown(t, hashed)
+
+	if _, err := hashed.Write("a.meta", bytes.NewReader([]byte("x"))); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := hashed.Keys(); len(keys) != 1 {
+		t.Errorf("expected the key to be listed, have %v", keys)
+	}
+}
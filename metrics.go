@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+/*
+Metrics observes the store's operations, e.g. to feed Prometheus counters and
+histograms. Every call reports how long the operation took and its error, nil
+on success. Implementations must be safe for concurrent use.
+*/
+type Metrics interface {
+	/*
+		ObserveWrite is called once per write with the bytes stored: by
+		Write and its variants, WriteIfNotExists, which stores nothing for a
+		key that exists, WriteWithMeta, WriteCAS, WriteBatch, Append, Copy
+		and Move, by every file Import stores, every change a Txn commits
+		and the Commit of a ResumableWriter. A ChunkedWrite counts as the
+		writes of its chunks and its manifest.
+	*/
+	ObserveWrite(bytes int64, d time.Duration, err error)
+
+	/*
+		ObserveRead is called when a stream returned by Read or one of its
+		variants, Open, ReadAt or ReadVersion is closed, with the bytes read
+		from it and the time since it was opened, and once per MapRead.
+		Failing to open counts as a read too.
+	*/
+	ObserveRead(bytes int64, d time.Duration, err error)
+
+	ObserveDelete(d time.Duration, err error)
+}
+
+/* nopMetrics is the Metrics of stores that have none configured. */
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveWrite(int64, time.Duration, error) {}
+func (nopMetrics) ObserveRead(int64, time.Duration, error)  {}
+func (nopMetrics) ObserveDelete(time.Duration, error)       {}
+
+/* metricsReader reports a read to Metrics when it is closed. */
+type metricsReader struct {
+	io.ReadCloser
+	metrics Metrics
+	start   time.Time
+	n       int64
+	err     error
+}
+
+func (r *metricsReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+/*
+WriteTo hands the stream to io.Copy as it is, so copying a plain object's file
+into a TCP connection, e.g. an http.ResponseWriter, still takes the sendfile
+fast path rather than bouncing every byte through Read.
+*/
+func (r *metricsReader) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, r.ReadCloser)
+	r.n += n
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+/* metricsSeeker is a metricsReader of a stream that can seek, as Open returns them. */
+type metricsSeeker struct {
+	metricsReader
+	seeker io.Seeker
+}
+
+func (r *metricsSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+func (r *metricsReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.err == nil {
+		r.err = err
+	}
+	r.metrics.ObserveRead(r.n, time.Since(r.start), r.err)
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	mu      sync.Mutex
+	writes  []int64
+	reads   []int64
+	deletes int
+	errs    int
+}
+
+func (m *recordingMetrics) ObserveWrite(bytes int64, _ time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes = append(m.writes, bytes)
+	if err != nil {
+		m.errs++
+	}
+}
+
+func (m *recordingMetrics) ObserveRead(bytes int64, _ time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads = append(m.reads, bytes)
+	if err != nil {
+		m.errs++
+	}
+}
+
+func (m *recordingMetrics) ObserveDelete(_ time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deletes++
+	if err != nil {
+		m.errs++
+	}
+}
+
+func TestStorageMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Metrics:           metrics,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("key", bytes.NewReader([]byte("12345"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadBytes("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics.writes) != 1 || metrics.writes[0] != 5 {
+		t.Errorf("unexpected writes %v", metrics.writes)
+	}
+	if len(metrics.reads) != 2 || metrics.reads[0] != 5 {
+		t.Errorf("unexpected reads %v", metrics.reads)
+	}
+	if metrics.deletes != 1 {
+		t.Errorf("expected 1 delete, have %d", metrics.deletes)
+	}
+	if metrics.errs != 1 {
+		t.Errorf("expected the missing key to be the only error, have %d", metrics.errs)
+	}
+}
+
+func TestStorageMetricsWriteVariants(t *testing.T) {
+	metrics := &recordingMetrics{}
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Metrics:           metrics,
+	})
+	defer teardown(t, s)
+
+	if _, _, err := s.WriteIfNotExists("new", bytes.NewReader([]byte("12345"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteWithMeta("meta", bytes.NewReader([]byte("123")), map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Copy("new", "copied"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("copied", "moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, _, err := s.Open("meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if fmt.Sprint(metrics.writes) != "[5 3 5 5]" {
+		t.Errorf("expected every write variant to be observed, have %v", metrics.writes)
+	}
+	if fmt.Sprint(metrics.reads) != "[3]" {
+		t.Errorf("expected the read through Open to be observed, have %v", metrics.reads)
+	}
+	if metrics.errs != 0 {
+		t.Errorf("expected no errors, have %d", metrics.errs)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/* MigrateReport counts what a Migrate run did. */
+type MigrateReport struct {
+	/* Moved are objects now at a different path. */
+	Moved int
+
+	/* Unchanged are objects that were already where the new transform puts them. */
+	Unchanged int
+
+	/* Duplicates are objects removed because their content was already stored at the new path. */
+	Duplicates int
+
+	/* Bytes is the size of all objects moved. */
+	Bytes int64
+}
+
+/*
+Migrate moves every object to the path newTransform lays it out on and makes
+newTransform the store's PathTransformFunc, e.g. after switching to a
+different digest or directory depth. A path can't be turned back into its key,
+so the key is derived from the content: every object is read and hashed under
+Hash, and ends up under that digest. Only ContentAddressed stores, whose keys
+are these digests, can be migrated: objects written with another Hash get new
+keys, the digests of their content under the new one. Objects are renamed
+rather than copied, with their sidecars, and directories left empty are
+removed. It must not run alongside other operations on the store.
+*/
+func (store *DiskStore) Migrate(newTransform PathTransformFunc) (MigrateReport, error) {
+	var report MigrateReport
+
+	if err := store.writable(); err != nil {
+		return report, err
+	}
+	if !store.ContentAddressed {
+		return report, ErrNotContentAddressed
+	}
+	if len(store.prefix) > 0 {
+		return report, fmt.Errorf("%w: Migrate of a Namespace view", errors.ErrUnsupported)
+	}
+
+	next := *store
+	next.PathTransformFunc = newTransform
+	next.foldCase()
+
+	var objects []string
+	err := store.walkDir(store.Root, func(path string, _ os.FileInfo) error {
+		objects = append(objects, path)
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return report, err
+	}
+
+	for _, path := range objects {
+		if err := next.migrateObject(path, &report); err != nil {
+			return report, err
+		}
+	}
+	store.counters.invalidate()
+	store.statCache.purge()
+
+	store.PathTransformFunc = next.PathTransformFunc
+	return report, nil
+}
+
+/* migrateObject moves the object at path to where its digest belongs under the store's transform. */
+func (store *DiskStore) migrateObject(path string, report *MigrateReport) error {
+	digest, err := store.digestFile(path)
+	if err != nil {
+		return err
+	}
+
+	dir, dst, err := store.paths(digest)
+	if err != nil {
+		return err
+	}
+	if dst == path {
+		report.Unchanged++
+		return nil
+	}
+
+	defer store.locks.lock(path, dst)()
+
+	info, err := store.FS.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := store.FS.Stat(dst); err == nil {
+		// the digest says the content is the same, one copy is enough
+		if err := store.FS.Remove(path); err != nil {
+			return err
+		}
+		store.checksums.forget(path)
+		if err := store.removeMeta(path); err != nil {
+			return err
+		}
+		if err := store.removeRefs(path); err != nil {
+			return err
+		}
+		report.Duplicates++
+	} else {
+		if err := store.FS.MkdirAll(dir, store.DirMode); err != nil {
+			return err
+		}
+		if err := store.renameRetry(path, dst); err != nil {
+			return err
+		}
+		store.bloom.add(dst)
+		store.checksums.rename(path, dst)
+
+		for _, suffix := range []string{metaFileSuffix, refsFileSuffix} {
+			if err := store.renameRetry(path+suffix, dst+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := store.syncDir(dir); err != nil {
+			return err
+		}
+
+		report.Moved++
+		report.Bytes += info.Size()
+	}
+
+	store.Logger.Info("migrated object", "from", path, "to", dst)
+	store.pruneEmptyDirs(filepath.Dir(path))
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageMigrate(t *testing.T) {
+	s := newContentAddressedStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("migrated")
+	key := writeContentAddressed(t, s, data)
+	if _, err := s.WriteWithMeta(key, bytes.NewReader(data), map[string]string{"kind": "doc"}); err != nil {
+		t.Fatal(err)
+	}
+	_, oldPath, _ := s.paths(key)
+
+	report, err := s.Migrate(NewDigestPathTransformFunc(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Moved != 1 || report.Bytes != int64(len(data)) {
+		t.Errorf("expected one object of %d bytes moved, have %+v", len(data), report)
+	}
+
+	_, newPath, _ := s.paths(key)
+	if newPath == oldPath {
+		t.Fatal("expected the store to use the new transform")
+	}
+	if b, _ := s.ReadBytes(key); string(b) != string(data) {
+		t.Errorf("expected the content under the same key, have %q", b)
+	}
+	if meta, _ := s.ReadMeta(key); meta["kind"] != "doc" {
+		t.Errorf("expected the metadata to move along, have %v", meta)
+	}
+	if _, err := os.Stat(filepath.Dir(oldPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the emptied directories to be removed, have %v", err)
+	}
+
+	if report, _ := s.Migrate(NewDigestPathTransformFunc(2)); report.Unchanged != 1 {
+		t.Errorf("expected migrating again to change nothing, have %+v", report)
+	}
+}
+
+func TestStorageMigrateHash(t *testing.T) {
+	opts := StorageOptions{
+		Root:              "casroot",
+		PathTransformFunc: NewDigestPathTransformFunc(5),
+		ContentAddressed:  true,
+		Hash:              sha1.New,
+	}
+	s := newStorageWithOptions(t, opts)
+	defer teardown(t, s)
+
+	data := []byte("rehashed")
+	sum := sha1.Sum(data)
+	if _, err := s.Write(hex.EncodeToString(sum[:]), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Hash = sha256.New
+	s = newStorageWithOptions(t, opts)
+	if _, err := s.Migrate(NewDigestPathTransformFunc(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	newSum := sha256.Sum256(data)
+	if b, _ := s.ReadBytes(hex.EncodeToString(newSum[:])); string(b) != string(data) {
+		t.Errorf("expected the content under its SHA-256 digest, have %q", b)
+	}
+	if corrupted, err := s.Verify(); err != nil || len(corrupted) != 0 {
+		t.Errorf("expected a clean scrub after migrating, have %v, %v", corrupted, err)
+	}
+}
+
+func TestStorageMigrateNotContentAddressed(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Migrate(DefaultPathTransformFunc); !errors.Is(err, ErrNotContentAddressed) {
+		t.Errorf("expected %v, have %v", ErrNotContentAddressed, err)
+	}
+}
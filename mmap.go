@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+MapRead maps the object stored under key into memory read-only and returns its
+content along with the function unmapping it, after which the slice must not be
+touched anymore. Random access to the slice costs no syscalls, unlike ReadAt,
+and pages are only read from disk as they are first touched. Overwriting the
+key meanwhile doesn't change the mapped content, as writes replace the file.
+
+Objects above MaxMapBytes fail with ErrObjectTooLarge. Compressed or encrypted
+objects can't be mapped, and neither can files of an FS without descriptors
+or on platforms without mmap: they are read into memory instead, which is what
+the unmap function then releases.
+*/
+func (store *DiskStore) MapRead(key string) ([]byte, func() error, error) {
+	start := time.Now()
+
+	b, unmap, err := store.mapRead(key)
+	store.Metrics.ObserveRead(int64(len(b)), time.Since(start), err)
+
+	return b, unmap, err
+}
+
+func (store *DiskStore) mapRead(key string) ([]byte, func() error, error) {
+	info, err := store.stat(key)
+	if err != nil {
+		store.expire(key, err)
+		return nil, nil, err
+	}
+
+	_, fullPathWithRoot, _ := store.paths(key)
+
+	size, err := store.contentSize(fullPathWithRoot, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	if store.MaxMapBytes > 0 && size > store.MaxMapBytes {
+		return nil, nil, fmt.Errorf("%w: mapping (%s) of %d bytes exceeds %d", ErrObjectTooLarge, key, size, store.MaxMapBytes)
+	}
+
+	file, err := store.openObject(key, fullPathWithRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the mapping stays valid once the file is closed
+	defer file.Close()
+
+	if f, ok := unwrapFile(file).(interface{ Fd() uintptr }); ok && size > 0 {
+		b, err := mmap(f.Fd(), int(size))
+		if err == nil {
+			var once sync.Once
+			return b, func() (err error) {
+				once.Do(func() { err = munmap(b) })
+				return err
+			}, nil
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return nil, nil, err
+		}
+	}
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, func() error { return nil }, nil
+}
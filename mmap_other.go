@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+/* mmap is unsupported here, MapRead reads objects into memory instead. */
+func mmap(uintptr, int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap([]byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestStorageMapRead(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxMapBytes:       1 << 16,
+	})
+	defer teardown(t, s)
+
+	content := bytes.Repeat([]byte("index entry "), 1000)
+	if _, err := s.WriteBytes("index", content); err != nil {
+		t.Fatal(err)
+	}
+
+	b, unmap, err := s.MapRead("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("expected the mapping to hold the content")
+	}
+
+	// a write replaces the file, the mapping keeps the old content
+	if _, err := s.WriteBytes("index", []byte("rewritten")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:12], []byte("index entry ")) {
+		t.Errorf("expected the mapping to be unaffected by the write, have %q", b[:12])
+	}
+	if err := unmap(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unmap(); err != nil {
+		t.Errorf("expected a second unmap to be a no-op, have %v", err)
+	}
+
+	if _, err := s.WriteBytes("large", make([]byte, 1<<16+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.MapRead("large"); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("expected ErrObjectTooLarge, have %v", err)
+	}
+}
+
+func TestStorageMapReadEncoded(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Compression:       CompressionGzip,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.WriteBytes("compressed", []byte("decoded into memory")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, unmap, err := s.MapRead("compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unmap()
+	if string(b) != "decoded into memory" {
+		t.Errorf("expected the decoded content, have %q", b)
+	}
+}
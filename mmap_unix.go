@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+/* mmap maps length bytes of the file fd read-only. */
+func mmap(fd uintptr, length int) ([]byte, error) {
+	return syscall.Mmap(int(fd), 0, length, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
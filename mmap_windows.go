@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+/* mmap maps length bytes of the file handle fd read-only through a file mapping object. */
+func mmap(fd uintptr, length int) ([]byte, error) {
+	mapping, err := syscall.CreateFileMapping(syscall.Handle(fd), nil, syscall.PAGE_READONLY, 0, 0, nil)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateFileMapping", err)
+	}
+	// the view keeps the mapping object alive on its own
+	defer syscall.CloseHandle(mapping)
+
+	addr, err := syscall.MapViewOfFile(mapping, syscall.FILE_MAP_READ, 0, 0, uintptr(length))
+	if err != nil {
+		return nil, os.NewSyscallError("MapViewOfFile", err)
+	}
+
+	// reinterpreting the address in place keeps vet from flagging a uintptr conversion
+	return unsafe.Slice(*(**byte)(unsafe.Pointer(&addr)), length), nil
+}
+
+func munmap(b []byte) error {
+	return os.NewSyscallError("UnmapViewOfFile", syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(&b[0]))))
+}
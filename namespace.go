@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Namespace returns a view of the store confined to the directory prefix below
+Root, e.g. one per tenant. Keys are laid out by the store's PathTransformFunc
+inside that directory, so Keys, Walk, Delete and Clear of the view only ever
+see the namespace's own objects. The view shares the store's locks, options
+and stats; Stats and MaxBytes keep covering the whole store.
+*/
+func (store *DiskStore) Namespace(prefix string) (*DiskStore, error) {
+	cleaned := filepath.ToSlash(filepath.Clean(prefix))
+	if len(prefix) == 0 || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") || filepath.IsAbs(prefix) {
+		return nil, fmt.Errorf("%w: namespace %q", ErrInvalidKey, prefix)
+	}
+
+	if store.CaseInsensitiveFS {
+		cleaned = escapeCase(cleaned)
+	}
+
+	ns := *store
+	ns.prefix = path.Join(store.prefix, cleaned)
+	ns.rootLock = nil
+
+	transform := store.PathTransformFunc
+	ns.PathTransformFunc = func(key string) PathKey {
+		pathKey := transform(key)
+		pathKey.Pathname = path.Join(cleaned, pathKey.Pathname)
+		return pathKey
+	}
+
+	return &ns, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestStorageNamespace(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	tenant1, err := s.Namespace("tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant2, err := s.Namespace("tenant2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tenant1.Write("a", bytes.NewReader([]byte("one"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenant2.Write("a", bytes.NewReader([]byte("two"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := tenant1.ReadBytes("a"); string(b) != "one" {
+		t.Errorf("expected tenant1's content, have %q", b)
+	}
+	if ok, _ := s.Has("a"); ok {
+		t.Error("expected the store itself to NOT have key a")
+	}
+
+	keys, err := tenant1.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("expected 1 key in tenant1, have %v", keys)
+	}
+
+	if err := tenant1.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := tenant1.Has("a"); ok {
+		t.Error("expected the cleared namespace to NOT have key a")
+	}
+	if b, _ := tenant2.ReadBytes("a"); string(b) != "two" {
+		t.Errorf("expected clearing tenant1 to keep tenant2's content, have %q", b)
+	}
+
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ObjectCount != 1 {
+		t.Errorf("expected 1 object left in the store, have %d", stats.ObjectCount)
+	}
+
+	for _, prefix := range []string{"", ".", "..", "../other", "/abs"} {
+		if _, err := s.Namespace(prefix); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected %v for namespace %q, have %v", ErrInvalidKey, prefix, err)
+		}
+	}
+}
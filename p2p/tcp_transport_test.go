@@ -18,6 +18,5 @@ func TestTCPTransport(t *testing.T) {
 
 	// Server
 	assert.Nil(t, tr.ListenAndAccept())
-
-	select {}
+	assert.Nil(t, tr.Close())
 }
\ No newline at end of file
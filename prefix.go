@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+DeletePrefix deletes the subtree of keys named by prefix, that is the key
+prefix itself and every key below prefix + "/", and returns how many objects
+it removed. Like KeysWithPrefix it needs a PathTransformFunc keeping keys
+readable, such as DefaultPathTransformFunc. The directory prefix transforms
+into is removed as a whole, so the subtree should not be written to
+meanwhile. With Trash or RefCounting the objects are deleted one by one
+instead, to keep them restorable or referenced. An empty prefix, which would
+clear the store, and prefixes escaping Root fail with ErrInvalidKey. When dry
+running, it returns how many objects it would remove.
+*/
+func (store *DiskStore) DeletePrefix(prefix string) (int, error) {
+	if err := store.writable(); err != nil {
+		return 0, err
+	}
+
+	prefix = strings.TrimRight(prefix, "/")
+	if len(prefix) == 0 {
+		return 0, fmt.Errorf("%w: empty prefix", ErrInvalidKey)
+	}
+
+	dir := filepath.Join(store.Root, store.PathTransformFunc(prefix).Pathname)
+	if !store.insideRoot(dir) || store.inReservedDir(dir) {
+		return 0, fmt.Errorf("%w: prefix %q", ErrInvalidKey, prefix)
+	}
+
+	var (
+		keys    []string
+		objects int
+	)
+	err := store.walkDir(dir, func(objectPath string, _ os.FileInfo) error {
+		objects++
+		if key, ok := store.keyOf(objectPath); ok {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if store.dryRunning() {
+		return store.previewDeletePrefix(dir, keys, objects)
+	}
+
+	if store.Trash || store.RefCounting {
+		err := store.DeleteBatch(keys)
+		return len(keys), err
+	}
+
+	start := time.Now()
+	err = store.FS.RemoveAll(dir)
+	store.Metrics.ObserveDelete(time.Since(start), err)
+
+	// the objects are gone without their sizes being subtracted
+	store.counters.invalidate()
+	store.statCache.purge()
+	store.checksums.forgetDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if store.PruneEmptyDirs {
+		store.pruneEmptyDirs(filepath.Dir(dir))
+	}
+
+	for _, key := range keys {
+		store.deleted(key)
+	}
+
+	return objects, nil
+}
+
+/*
+previewDeletePrefix reports what DeletePrefix would remove: the whole of dir,
+or with Trash or RefCounting the objects of keys a Delete would take away,
+moving them into the trash or dropping their last reference.
+*/
+func (store *DiskStore) previewDeletePrefix(dir string, keys []string, objects int) (int, error) {
+	if !store.Trash && !store.RefCounting {
+		return objects, store.removeAll(dir)
+	}
+
+	for _, key := range keys {
+		_, fullPathWithRoot, _ := store.paths(key)
+
+		info, err := store.FS.Stat(fullPathWithRoot)
+		if err != nil {
+			continue
+		}
+
+		n, err := store.refs(fullPathWithRoot)
+		if err != nil {
+			return 0, err
+		}
+		if n > 1 {
+			continue
+		}
+
+		store.wouldRemove(fullPathWithRoot, info.Size())
+		store.wouldRemoveSidecars(fullPathWithRoot)
+	}
+
+	return len(keys), nil
+}
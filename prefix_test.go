@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStorageDeletePrefix(t *testing.T) {
+	var deleted []string
+	s := newStorageWithOptions(t, StorageOptions{
+		OnDelete: func(key string) { deleted = append(deleted, key) },
+	})
+	defer teardown(t, s)
+
+	for _, key := range []string{"docs", "docs/a", "docs/sub/b", "docsx", "other"} {
+		if _, err := s.Write(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := s.DeletePrefix("docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 removed objects, have %d", n)
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"docsx", "other"}) {
+		t.Errorf("unexpected remaining keys %v", keys)
+	}
+
+	slices.Sort(deleted)
+	if !slices.Equal(deleted, []string{"docs", "docs/a", "docs/sub/b"}) {
+		t.Errorf("unexpected OnDelete calls %v", deleted)
+	}
+
+	if n, err := s.DeletePrefix("missing"); n != 0 || err != nil {
+		t.Errorf("expected (0, nil) for a missing prefix, have (%d, %v)", n, err)
+	}
+
+	for _, prefix := range []string{"", "/", "..", "../outside", ".trash"} {
+		if _, err := s.DeletePrefix(prefix); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected %v for prefix %q, have %v", ErrInvalidKey, prefix, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+/* progressInterval is how many bytes pass between two Progress calls. */
+const progressInterval = 1 << 20
+
+/* progress feeds the Progress hook of a single operation. */
+type progress struct {
+	fn       func(bytesDone, bytesTotal int64)
+	total    int64
+	done     int64
+	reported int64
+}
+
+/* newProgress returns the tracker of an operation handling total bytes, -1 if unknown; nil without a hook. */
+func (store *DiskStore) newProgress(total int64) *progress {
+	if store.Progress == nil {
+		return nil
+	}
+	return &progress{fn: store.Progress, total: total, reported: -1}
+}
+
+func (p *progress) add(n int) {
+	p.done += int64(n)
+	if p.done-p.reported >= progressInterval {
+		p.report()
+	}
+}
+
+/* finish reports the final count unless it was just reported. */
+func (p *progress) finish() {
+	if p.done != p.reported {
+		p.report()
+	}
+}
+
+func (p *progress) report() {
+	p.fn(p.done, p.total)
+	p.reported = p.done
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.add(n)
+	if err == io.EOF {
+		pr.p.finish()
+	}
+	return n, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.add(n)
+	return n, err
+}
+
+/* lengthOf returns the number of bytes left in r when it can tell, -1 otherwise. */
+func lengthOf(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStorageProgress(t *testing.T) {
+	var calls [][2]int64
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Progress: func(done, total int64) {
+			calls = append(calls, [2]int64{done, total})
+		},
+	})
+	defer teardown(t, s)
+
+	data := bytes.Repeat([]byte("x"), 3*progressInterval+10)
+	if _, err := s.Write("big", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(data))
+	if len(calls) != 4 {
+		t.Errorf("expected 4 progress calls, have %v", calls)
+	}
+	if last := calls[len(calls)-1]; last != [2]int64{size, size} {
+		t.Errorf("expected the last call to report (%d, %d), have %v", size, size, last)
+	}
+
+	// a reader of unknown length reports no total
+	calls = nil
+	if _, err := s.Write("stream", io.MultiReader(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if last := calls[len(calls)-1]; last != [2]int64{size, -1} {
+		t.Errorf("expected the last call to report (%d, -1), have %v", size, last)
+	}
+
+	calls = nil
+	if err := s.Export(io.Discard, CompressionNone); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) == 0 || calls[len(calls)-1][0] < 2*size {
+		t.Errorf("expected Export to report at least %d bytes, have %v", 2*size, calls)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+/* ErrRangeNotSatisfiable is returned by ReadAt for ranges outside of the stored content. */
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+/*
+ReadAt returns a stream over length bytes of the content stored under key,
+starting at off. A range running past the end is cut short at the end of the
+content. Plain objects are seeked into; compressed or encrypted ones have to
+be decoded from the start, so the bytes before off are read and discarded.
+*/
+func (store *DiskStore) ReadAt(key string, off, length int64) (io.ReadCloser, error) {
+	start := time.Now()
+
+	size, err := store.Size(key)
+	if err != nil {
+		return store.observeRead(start, nil, err)
+	}
+
+	if off < 0 || length < 0 || off > size {
+		return nil, fmt.Errorf("%w: %d bytes at %d of (%s) holding %d bytes", ErrRangeNotSatisfiable, length, off, key, size)
+	}
+
+	r, err := store.openKey(key)
+	if err != nil {
+		return store.observeRead(start, nil, err)
+	}
+
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err = seeker.Seek(off, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, r, off)
+	}
+	if err != nil {
+		r.Close()
+		return store.observeRead(start, nil, err)
+	}
+
+	return store.observeRead(start, &limitedReadCloser{Reader: io.LimitReader(unwrapFile(r), length), Closer: r}, nil)
+}
+
+/* limitedReadCloser reads from a limited view of a stream and closes the stream itself. */
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+/* WriteTo passes on the *io.LimitedReader, which sendfile also handles over a file. */
+func (r *limitedReadCloser) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, r.Reader)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStorageReadAt(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionGzip} {
+		s := newStorageWithOptions(t, StorageOptions{
+			PathTransformFunc: CASPathTransformFunc,
+			Compression:       compression,
+		})
+
+		data := []byte("0123456789")
+		if _, err := s.Write("digits", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		ranges := []struct {
+			off, length int64
+			want        string
+		}{
+			{0, 10, "0123456789"},
+			{3, 4, "3456"},
+			{8, 100, "89"},
+			{10, 5, ""},
+		}
+		for _, rng := range ranges {
+			r, err := s.ReadAt("digits", rng.off, rng.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != rng.want {
+				t.Errorf("compression %d: expected %q at %d+%d, have %q", compression, rng.want, rng.off, rng.length, b)
+			}
+		}
+
+		for _, off := range []int64{-1, 11} {
+			if _, err := s.ReadAt("digits", off, 1); !errors.Is(err, ErrRangeNotSatisfiable) {
+				t.Errorf("expected %v at %d, have %v", ErrRangeNotSatisfiable, off, err)
+			}
+		}
+
+		if _, err := s.ReadAt("missing_key", 0, 1); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+		}
+
+		teardown(t, s)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+readPools recycle the bufio.Readers of a ReadBufferSize store, and the
+metricsReaders they read through, across reads: millions of small reads would
+otherwise allocate a buffer of ReadBufferSize each. They go back into the
+pools when their BufferedReadCloser is closed, which cuts them loose first,
+so nothing the caller still holds can reach them.
+*/
+type readPools struct {
+	buffers sync.Pool
+	metrics sync.Pool
+}
+
+func newReadPools(bufferSize int) *readPools {
+	return &readPools{
+		buffers: sync.Pool{New: func() any { return bufio.NewReaderSize(nil, bufferSize) }},
+		metrics: sync.Pool{New: func() any { return new(metricsReader) }},
+	}
+}
+
+/*
+BufferedReadCloser is the stream reads return with ReadBufferSize set. Its
+bufio.Reader methods, like Peek or ReadString, read ahead from the object's
+stream; Close closes the stream and with it the file. Its buffer is reused by
+later reads once it is closed, after which reading fails with os.ErrClosed.
+*/
+type BufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+
+	// pools is where Reader and metrics go back to on Close
+	pools   *readPools
+	metrics *metricsReader
+}
+
+/* bufferedRead buffers the stream r of an object opened at start, out of the store's pools. */
+func (store *DiskStore) bufferedRead(r io.ReadCloser, start time.Time) *BufferedReadCloser {
+	m := store.readPools.metrics.Get().(*metricsReader)
+	*m = metricsReader{ReadCloser: r, metrics: store.Metrics, start: start}
+	br := store.readPools.buffers.Get().(*bufio.Reader)
+	br.Reset(m)
+
+	return &BufferedReadCloser{Reader: br, Closer: m, pools: store.readPools, metrics: m}
+}
+
+/* Close closes the object's stream and recycles the buffer. Closing again fails with os.ErrClosed. */
+func (b *BufferedReadCloser) Close() error {
+	if b.Closer == nil {
+		return os.ErrClosed
+	}
+
+	err := b.Closer.Close()
+	b.Closer = nil
+
+	b.Reader.Reset(nil)
+	b.pools.buffers.Put(b.Reader)
+
+	*b.metrics = metricsReader{}
+	b.pools.metrics.Put(b.metrics)
+	b.metrics = nil
+	// the smallest buffer bufio allows, for reads after Close to fail on
+	b.Reader = bufio.NewReaderSize(closedReader{}, 16)
+
+	return err
+}
+
+/* closedReader is the stream of a closed BufferedReadCloser. */
+type closedReader struct{}
+
+func (closedReader) Read([]byte) (int, error) {
+	return 0, os.ErrClosed
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStorageReadPoolReuse(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{PathTransformFunc: CASPathTransformFunc, ReadBufferSize: 4096})
+	defer teardown(t, s)
+
+	for _, key := range []string{"first", "second"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte(key+" content"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := s.Read("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// likely handed the buffer first just gave back
+	second, err := s.Read("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if _, err := first.Read(make([]byte, 8)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected reading a closed stream to fail with %v, have %v", os.ErrClosed, err)
+	}
+	if err := first.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected closing twice to fail with %v, have %v", os.ErrClosed, err)
+	}
+
+	if b, _ := io.ReadAll(second); string(b) != "second content" {
+		t.Errorf("expected the second stream untouched by the first, have %q", b)
+	}
+}
+
+/* BenchmarkBufferedReads reads small objects through a ReadBufferSize store, reporting the allocations per read. */
+func BenchmarkBufferedReads(b *testing.B) {
+	content := bytes.Repeat([]byte("x"), 1024)
+
+	s, err := NewDiskStore(StorageOptions{
+		Root:              b.TempDir(),
+		PathTransformFunc: CASPathTransformFunc,
+		ReadBufferSize:    64 << 10,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := s.WriteBytes("small", content); err != nil {
+		b.Fatal(err)
+	}
+
+	p := make([]byte, 64)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	for i := 0; i < b.N; i++ {
+		r, err := s.Read("small")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			if _, err := r.Read(p); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+With RefCounting the number of references to an object is kept in a sidecar
+named after the object's file with refsFileSuffix appended. Objects without
+one have a single reference.
+*/
+const refsFileSuffix = ".refs"
+
+/* isRefsFile reports whether name is the reference count sidecar of an object. */
+func isRefsFile(name string) bool {
+	return strings.HasSuffix(name, refsFileSuffix)
+}
+
+func refsPath(fullPathWithRoot string) string {
+	return fullPathWithRoot + refsFileSuffix
+}
+
+/*
+RefCount returns the number of references to the object under key, i.e. the
+writes storing it minus the deletes since. It is always 1 without RefCounting.
+*/
+func (store *DiskStore) RefCount(key string) (int, error) {
+	if _, err := store.stat(key); err != nil {
+		return 0, err
+	}
+
+	_, fullPathWithRoot, _ := store.paths(key)
+
+	return store.refs(fullPathWithRoot)
+}
+
+/* refs reads the reference count of the existing object at fullPathWithRoot. */
+func (store *DiskStore) refs(fullPathWithRoot string) (int, error) {
+	if !store.RefCounting {
+		return 1, nil
+	}
+
+	file, err := store.FS.Open(refsPath(fullPathWithRoot))
+	if errors.Is(err, os.ErrNotExist) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+/* setRefs stores n as the reference count of the object at fullPathWithRoot in dir. */
+func (store *DiskStore) setRefs(dir, fullPathWithRoot string, n int) error {
+	if n == 1 {
+		// a single reference is the default, there's no need to keep a file
+		return store.removeRefs(fullPathWithRoot)
+	}
+
+	return store.writeSidecar(dir, refsPath(fullPathWithRoot), []byte(strconv.Itoa(n)))
+}
+
+/*
+addRef accounts for a write that just stored the object at fullPathWithRoot,
+which replaced an existing one unless created is set.
+*/
+func (store *DiskStore) addRef(dir, fullPathWithRoot string, created bool) error {
+	if !store.RefCounting {
+		return nil
+	}
+
+	if created {
+		return store.setRefs(dir, fullPathWithRoot, 1)
+	}
+
+	n, err := store.refs(fullPathWithRoot)
+	if err != nil {
+		return err
+	}
+
+	return store.setRefs(dir, fullPathWithRoot, n+1)
+}
+
+/* removeRefs removes the reference count sidecar of the object at fullPathWithRoot, if any. */
+func (store *DiskStore) removeRefs(fullPathWithRoot string) error {
+	if err := store.FS.Remove(refsPath(fullPathWithRoot)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+/* moveRefs hands the reference count of the object at srcPath to the one it was moved to. */
+func (store *DiskStore) moveRefs(srcPath, dstDir, dstPath string) error {
+	if !store.RefCounting {
+		return nil
+	}
+
+	n, err := store.refs(srcPath)
+	if err != nil {
+		return err
+	}
+
+	if err := store.setRefs(dstDir, dstPath, n); err != nil {
+		return err
+	}
+
+	return store.removeRefs(srcPath)
+}
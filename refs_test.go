@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStorageRefCounting(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		Root:              "casroot",
+		PathTransformFunc: NewDigestPathTransformFunc(5),
+		ContentAddressed:  true,
+		RefCounting:       true,
+	})
+	defer teardown(t, s)
+
+	data := []byte("shared by two owners")
+	key := writeContentAddressed(t, s, data)
+	if _, _, err := s.WriteIfNotExists(key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.RefCount(key); err != nil || n != 2 {
+		t.Errorf("expected 2 references, have %d (%v)", n, err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 1 {
+		t.Errorf("expected the count sidecar to not be listed, have %v", keys)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Has(key); !ok {
+		t.Error("expected the object to survive while referenced")
+	}
+	if n, err := s.RefCount(key); err != nil || n != 1 {
+		t.Errorf("expected 1 reference, have %d (%v)", n, err)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Has(key); ok {
+		t.Error("expected the object to be gone with its last reference")
+	}
+}
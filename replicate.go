@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/* SyncReport counts the objects a SyncTo run handled. */
+type SyncReport struct {
+	Copied  int
+	Skipped int
+	Failed  int
+
+	/* Bytes is the on-disk size of the copied objects. */
+	Bytes int64
+}
+
+/*
+SyncTo copies the objects missing or outdated in dst from store, making dst
+an incremental backup. Both stores must lay keys out the same way, with the
+same PathTransformFunc and encoding options, since objects are copied as
+stored. On a ContentAddressed store an object present in dst is already up
+to date; otherwise it is copied again when its size differs or the source is
+newer. Metadata sidecars go along with their objects.
+
+Objects that fail to copy are logged, counted and skipped; only errors
+walking store end the sync early.
+*/
+func (store *DiskStore) SyncTo(dst *DiskStore) (SyncReport, error) {
+	if err := dst.writable(); err != nil {
+		return SyncReport{}, err
+	}
+
+	var report SyncReport
+
+	p := store.newProgress(-1)
+	if p != nil {
+		defer p.finish()
+	}
+
+	base := filepath.Join(store.Root, store.prefix)
+	err := store.walkDir(base, func(srcPath string, info os.FileInfo) error {
+		rel, err := filepath.Rel(base, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst.Root, dst.prefix, rel)
+
+		if dst.upToDate(dstPath, info, store.ContentAddressed) {
+			report.Skipped++
+			return nil
+		}
+
+		n, err := store.syncObject(dst, srcPath, dstPath, p)
+		if err != nil {
+			store.Logger.Error("sync failed", "path", srcPath, "err", err)
+			report.Failed++
+			return nil
+		}
+
+		report.Copied++
+		report.Bytes += n
+
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+
+	return report, err
+}
+
+/* upToDate reports whether the object at path needs no copy of the source object described by src. */
+func (store *DiskStore) upToDate(path string, src os.FileInfo, contentAddressed bool) bool {
+	info, err := store.FS.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	if contentAddressed {
+		return true
+	}
+
+	return info.Size() == src.Size() && !src.ModTime().After(info.ModTime())
+}
+
+/* syncObject copies the object at srcPath of store, with its sidecar, to dstPath of dst. */
+func (store *DiskStore) syncObject(dst *DiskStore, srcPath, dstPath string, p *progress) (int64, error) {
+	if !dst.insideRoot(dstPath) {
+		return 0, ErrInvalidKey
+	}
+
+	dstDir := filepath.Dir(dstPath)
+
+	defer dst.locks.lock(dstPath)()
+
+	if err := dst.FS.MkdirAll(dstDir, dst.DirMode); err != nil {
+		return 0, err
+	}
+
+	info, err := store.syncFile(dst, srcPath, dstDir, dstPath, p)
+	if err != nil {
+		return 0, err
+	}
+
+	meta, err := store.openRetry(metaPath(srcPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return info.Size(), dst.removeMeta(dstPath)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer meta.Close()
+
+	return info.Size(), dst.writeFile(dstDir, metaPath(dstPath), meta)
+}
+
+/* syncFile copies the object file of syncObject, closing it before the sidecar is opened. */
+func (store *DiskStore) syncFile(dst *DiskStore, srcPath, dstDir, dstPath string, p *progress) (os.FileInfo, error) {
+	src, err := store.openRetry(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	old, _ := dst.FS.Stat(dstPath)
+
+	var r io.Reader = src
+	if p != nil {
+		r = &progressReader{r: src, p: p}
+	}
+
+	if err := dst.writeFile(dstDir, dstPath, r); err != nil {
+		return nil, err
+	}
+	dst.counters.replaced(old, info.Size())
+	dst.bloom.add(dstPath)
+	dst.checksums.forget(dstPath)
+
+	return info, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStorageSyncTo(t *testing.T) {
+	src := newStorage(t)
+	defer teardown(t, src)
+	dst := newStorageWithOptions(t, StorageOptions{
+		Root:              "backuproot",
+		PathTransformFunc: CASPathTransformFunc,
+	})
+	defer teardown(t, dst)
+
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		if _, err := src.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := src.SyncTo(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 3 || report.Skipped != 0 || report.Failed != 0 {
+		t.Errorf("unexpected first sync %+v", report)
+	}
+
+	// only the changed object is copied again
+	if _, err := src.WriteWithMeta("key_1", bytes.NewReader([]byte("changed")), map[string]string{"v": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = src.SyncTo(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 1 || report.Skipped != 2 || report.Failed != 0 {
+		t.Errorf("unexpected incremental sync %+v", report)
+	}
+
+	if b, _ := dst.ReadBytes("key_1"); string(b) != "changed" {
+		t.Errorf("expected the backup to hold the change, have %q", b)
+	}
+	if meta, _ := dst.ReadMeta("key_1"); meta["v"] != "2" {
+		t.Errorf("expected the metadata to be synced, have %v", meta)
+	}
+}
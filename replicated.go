@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"sync"
+)
+
+/* ErrQuorumNotReached is returned by ReplicatedStore writes reaching fewer than Quorum replicas. */
+var ErrQuorumNotReached = errors.New("write quorum not reached")
+
+/* ReplicaError is the failure of an operation on one replica of a ReplicatedStore. */
+type ReplicaError struct {
+	/* Replica is the index of the replica in Replicas. */
+	Replica int
+	Err     error
+}
+
+func (e *ReplicaError) Error() string {
+	return fmt.Sprintf("replica %d: %v", e.Replica, e.Err)
+}
+
+func (e *ReplicaError) Unwrap() error {
+	return e.Err
+}
+
+/*
+ReplicatedStore mirrors every object onto several stores, e.g. DiskStores on
+different disks, so losing one of them loses no data. Writes go to all
+replicas at once, reads are served by the first replica that has the object.
+Unlike a ShardedStore, every replica holds every key.
+*/
+type ReplicatedStore struct {
+	Replicas []Store
+
+	/*
+		Quorum is how many replicas a Write must reach to succeed, all of
+		them when zero. A write succeeds once at least Quorum replicas
+		succeeded, and the replicas that failed stay degraded until the key
+		is written again.
+	*/
+	Quorum int
+
+	/*
+		Verify, when set, declares keys to be the hex digests of their
+		content under it: Read then hashes an object, buffering it in
+		memory, and falls back to the next replica when it doesn't match.
+	*/
+	Verify func() hash.Hash
+
+	/*
+		OnReplicaError is called with every *ReplicaError, including those
+		of operations that succeeded on enough other replicas, so monitoring
+		can tell that a replica is degraded.
+	*/
+	OnReplicaError func(op, key string, err *ReplicaError)
+}
+
+var _ Store = (*ReplicatedStore)(nil)
+
+/* NewReplicatedStore returns a ReplicatedStore writing to all of replicas. */
+func NewReplicatedStore(replicas ...Store) *ReplicatedStore {
+	return &ReplicatedStore{Replicas: replicas}
+}
+
+/* quorum returns how many replicas a write must reach. */
+func (store *ReplicatedStore) quorum() int {
+	if store.Quorum <= 0 || store.Quorum > len(store.Replicas) {
+		return len(store.Replicas)
+	}
+	return store.Quorum
+}
+
+/* failed wraps err of replica i in a ReplicaError and reports it to OnReplicaError. */
+func (store *ReplicatedStore) failed(op, key string, i int, err error) error {
+	replicaErr := &ReplicaError{Replica: i, Err: err}
+	if store.OnReplicaError != nil {
+		store.OnReplicaError(op, key, replicaErr)
+	}
+	return replicaErr
+}
+
+/* Has reports whether any replica holds key. It only fails when no replica could answer. */
+func (store *ReplicatedStore) Has(key string) (bool, error) {
+	var errs []error
+	for i, replica := range store.Replicas {
+		ok, err := replica.Has(key)
+		if err != nil {
+			errs = append(errs, store.failed("has", key, i, err))
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(store.Replicas) {
+		return false, errors.Join(errs...)
+	}
+	return false, nil
+}
+
+/* Size returns the size of key on the first replica holding it. */
+func (store *ReplicatedStore) Size(key string) (int64, error) {
+	var errs []error
+	for i, replica := range store.Replicas {
+		size, err := replica.Size(key)
+		if err == nil {
+			return size, nil
+		}
+		errs = append(errs, store.replicaMiss("size", key, i, err))
+	}
+	return 0, errors.Join(errs...)
+}
+
+/*
+Read returns the content of key from the first replica holding it, trying the
+next one when a replica fails or, with Verify, returns corrupted content.
+*/
+func (store *ReplicatedStore) Read(key string) (io.ReadCloser, error) {
+	var errs []error
+	for i, replica := range store.Replicas {
+		r, err := store.read(replica, key)
+		if err == nil {
+			return r, nil
+		}
+		errs = append(errs, store.replicaMiss("read", key, i, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+/* read reads key from replica, verifying its content with Verify. */
+func (store *ReplicatedStore) read(replica Store, key string) (io.ReadCloser, error) {
+	r, err := replica.Read(key)
+	if err != nil || store.Verify == nil {
+		return r, err
+	}
+	defer r.Close()
+
+	buf := new(bytes.Buffer)
+	hasher := store.Verify()
+	if _, err := io.Copy(io.MultiWriter(buf, hasher), r); err != nil {
+		return nil, err
+	}
+
+	if digest := hex.EncodeToString(hasher.Sum(nil)); digest != key {
+		return nil, fmt.Errorf("%w (%s): have digest %s", ErrCorrupted, key, digest)
+	}
+
+	return io.NopCloser(buf), nil
+}
+
+/*
+replicaMiss is failed for lookups, except that a replica lacking key isn't
+reported to OnReplicaError: that is what every replica says about keys that
+were never written.
+*/
+func (store *ReplicatedStore) replicaMiss(op, key string, i int, err error) error {
+	if errors.Is(err, ErrKeyNotFound) {
+		return &ReplicaError{Replica: i, Err: err}
+	}
+	return store.failed(op, key, i, err)
+}
+
+/*
+Write streams r to all replicas at once and returns once each is done. It
+fails with ErrQuorumNotReached, joined with the errors of the replicas that
+failed, when fewer than Quorum succeeded. There is no rollback: the replicas
+that succeeded are left with the new content, replacing what they held under
+key before, while the others keep the old content or none, so the replicas are
+inconsistent until key is written again. A replica failing midway is dropped
+while the others carry on.
+*/
+func (store *ReplicatedStore) Write(key string, r io.Reader) (int64, error) {
+	var (
+		wg      sync.WaitGroup
+		written = make([]int64, len(store.Replicas))
+		errs    = make([]error, len(store.Replicas))
+		fanout  = &fanoutWriter{dropped: make([]bool, len(store.Replicas))}
+	)
+	for i, replica := range store.Replicas {
+		pr, pw := io.Pipe()
+		fanout.pws = append(fanout.pws, pw)
+
+		wg.Add(1)
+		go func(i int, replica Store) {
+			defer wg.Done()
+			written[i], errs[i] = replica.Write(key, pr)
+			// unblocks the fanout once the replica gave up
+			pr.CloseWithError(errs[i])
+		}(i, replica)
+	}
+
+	_, err := io.Copy(fanout, r)
+	for _, pw := range fanout.pws {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+
+	var (
+		n         int64
+		succeeded int
+		failures  []error
+	)
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, store.failed("write", key, i, err))
+			continue
+		}
+		n = written[i]
+		succeeded++
+	}
+
+	if succeeded < store.quorum() {
+		return 0, fmt.Errorf("%w (%s): %d of %d replicas written: %w", ErrQuorumNotReached, key, succeeded, len(store.Replicas), errors.Join(failures...))
+	}
+
+	return n, nil
+}
+
+/*
+fanoutWriter copies every Write into each of its pipes, dropping those whose
+reader went away. It only fails once all of them did.
+*/
+type fanoutWriter struct {
+	pws     []*io.PipeWriter
+	dropped []bool
+	failed  int
+}
+
+func (w *fanoutWriter) Write(p []byte) (int, error) {
+	for i, pw := range w.pws {
+		if w.dropped[i] {
+			continue
+		}
+		if _, err := pw.Write(p); err != nil {
+			w.dropped[i] = true
+			w.failed++
+		}
+	}
+
+	if w.failed == len(w.pws) {
+		return 0, errors.New("all replicas failed")
+	}
+	return len(p), nil
+}
+
+/* Delete removes key from all replicas, failing with the errors of those it couldn't remove it from. */
+func (store *ReplicatedStore) Delete(key string) error {
+	var errs []error
+	for i, replica := range store.Replicas {
+		if err := replica.Delete(key); err != nil {
+			errs = append(errs, store.failed("delete", key, i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+Keys returns the keys held by any replica, in lexical order. It only fails
+when no replica could list its keys.
+*/
+func (store *ReplicatedStore) Keys() ([]string, error) {
+	seen := map[string]bool{}
+	var errs []error
+	for i, replica := range store.Replicas {
+		keys, err := replica.Keys()
+		if err != nil {
+			errs = append(errs, store.failed("keys", "", i, err))
+			continue
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(store.Replicas) {
+		return nil, errors.Join(errs...)
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+/* Clear clears every replica, failing with the errors of those it couldn't clear. */
+func (store *ReplicatedStore) Clear() error {
+	var errs []error
+	for i, replica := range store.Replicas {
+		if err := replica.Clear(); err != nil {
+			errs = append(errs, store.failed("clear", "", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
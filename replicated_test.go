@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+)
+
+/* failingStore is a Store whose writes fail, like one on a dead disk. */
+type failingStore struct {
+	*MemoryStore
+}
+
+func (failingStore) Write(key string, r io.Reader) (int64, error) {
+	return 0, errors.New("disk failed")
+}
+
+func TestReplicatedStoreQuorum(t *testing.T) {
+	healthy := []*MemoryStore{NewMemoryStore(nil), NewMemoryStore(nil)}
+	store := NewReplicatedStore(healthy[0], failingStore{NewMemoryStore(nil)}, healthy[1])
+
+	var reported []*ReplicaError
+	store.OnReplicaError = func(op, key string, err *ReplicaError) {
+		reported = append(reported, err)
+	}
+
+	if _, err := store.Write("doc", bytes.NewReader([]byte("content"))); !errors.Is(err, ErrQuorumNotReached) {
+		t.Fatalf("expected the quorum of all replicas to fail, have %v", err)
+	}
+
+	store.Quorum = 2
+	n, err := store.Write("doc", bytes.NewReader([]byte("content")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 {
+		t.Errorf("expected 7 bytes written, have %d", n)
+	}
+	for i, replica := range healthy {
+		if ok, _ := replica.Has("doc"); !ok {
+			t.Errorf("expected healthy replica %d to hold the object", i)
+		}
+	}
+	if len(reported) != 2 || reported[1].Replica != 1 {
+		t.Errorf("expected the failed replica to be reported twice, have %v", reported)
+	}
+
+	if err := store.Delete("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Has("doc"); ok {
+		t.Error("expected Delete to remove the object from all replicas")
+	}
+}
+
+func TestReplicatedStoreVerifiedRead(t *testing.T) {
+	content := []byte("replicated content")
+	sum := sha256.Sum256(content)
+	key := hex.EncodeToString(sum[:])
+
+	corrupted, intact := NewMemoryStore(nil), NewMemoryStore(nil)
+	store := NewReplicatedStore(corrupted, intact)
+	store.Verify = sha256.New
+
+	if _, err := store.Write(key, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	// bit rot on the first replica
+	if _, err := corrupted.Write(key, bytes.NewReader([]byte("replicated c0ntent"))); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(b, content) {
+		t.Errorf("expected the intact replica's content, have %q", b)
+	}
+
+	if _, err := store.Read("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a missing key, have %v", err)
+	}
+}
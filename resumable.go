@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+An upload in progress keeps its content in a file named after the object's
+file with partialFileSuffix appended, and the number of bytes received so far
+in one with offsetFileSuffix appended, next to where the object will be. GC
+removes the files of uploads left untouched for longer than GCGracePeriod.
+*/
+const (
+	partialFileSuffix = ".partial"
+	offsetFileSuffix  = ".offset"
+)
+
+/* isUploadFile reports whether name belongs to an upload in progress. */
+func isUploadFile(name string) bool {
+	return strings.HasSuffix(name, partialFileSuffix) || strings.HasSuffix(name, offsetFileSuffix)
+}
+
+/*
+ResumableWriter uploads content for a key across interruptions, e.g. of a
+client on a flaky connection: every Write is recorded, so after a crash or a
+dropped connection a new ResumableWriter for the key continues at Offset.
+The key only changes once Commit succeeds. There must be only one
+ResumableWriter per key at a time.
+*/
+type ResumableWriter struct {
+	store  *DiskStore
+	key    string
+	dir    string
+	path   string
+	file   File
+	offset int64
+}
+
+/*
+ResumableWriter starts or resumes the upload for key. Content written to it is
+appended at Offset(key), anything received beyond the recorded offset before
+an interruption is discarded.
+*/
+func (store *DiskStore) ResumableWriter(key string) (*ResumableWriter, error) {
+	if err := store.writable(); err != nil {
+		return nil, err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.FS.MkdirAll(pathnameWithRoot, store.DirMode); err != nil {
+		return nil, err
+	}
+
+	offset, err := store.uploadOffset(fullPathWithRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := store.FS.OpenFile(fullPathWithRoot+partialFileSuffix, os.O_WRONLY|os.O_CREATE, store.FileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// a tail written after the last recorded offset is discarded by overwriting it
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &ResumableWriter{
+		store:  store,
+		key:    key,
+		dir:    pathnameWithRoot,
+		path:   fullPathWithRoot,
+		file:   file,
+		offset: offset,
+	}, nil
+}
+
+/*
+Offset returns how many bytes of the upload for key were received, the offset
+the next ResumableWriter continues at. It is 0 when there is no upload.
+*/
+func (store *DiskStore) Offset(key string) (int64, error) {
+	_, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return store.uploadOffset(fullPathWithRoot)
+}
+
+/* uploadOffset reads the recorded offset of the upload to fullPathWithRoot, capped at what its partial file holds. */
+func (store *DiskStore) uploadOffset(fullPathWithRoot string) (int64, error) {
+	b, err := readFileFS(store.FS, fullPathWithRoot+offsetFileSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := store.FS.Stat(fullPathWithRoot + partialFileSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return min(offset, info.Size()), nil
+}
+
+/*
+Write appends p to the upload and records the new offset. With Sync the
+content is fsynced before the offset claims it.
+*/
+func (rw *ResumableWriter) Write(p []byte) (int, error) {
+	n, err := rw.file.Write(p)
+	if n == 0 {
+		return n, err
+	}
+
+	if rw.store.Sync {
+		if serr := rw.file.Sync(); serr != nil {
+			return 0, serr
+		}
+	}
+
+	rw.offset += int64(n)
+	if werr := rw.store.writeSidecar(rw.dir, rw.path+offsetFileSuffix, []byte(strconv.FormatInt(rw.offset, 10))); werr != nil {
+		rw.offset -= int64(n)
+		return 0, werr
+	}
+
+	return n, err
+}
+
+/* Offset returns the bytes of the upload received so far. */
+func (rw *ResumableWriter) Offset() int64 {
+	return rw.offset
+}
+
+/*
+Commit stores the uploaded content under the key like a Write, atomically
+replacing what the key held, and removes the upload's files. The content is
+copied into place, so that compression, encryption, quotas and versions apply
+to it as to any other write.
+*/
+func (rw *ResumableWriter) Commit() (int64, error) {
+	if err := rw.file.Close(); err != nil {
+		return 0, err
+	}
+
+	file, err := rw.store.FS.Open(rw.path + partialFileSuffix)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	result, err := rw.store.writeStream(context.Background(), rw.key, io.LimitReader(file, rw.offset))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Bytes, rw.store.removeUpload(rw.path)
+}
+
+/* Abort discards the upload, leaving the key as it was. */
+func (rw *ResumableWriter) Abort() error {
+	rw.file.Close()
+	return rw.store.removeUpload(rw.path)
+}
+
+/* removeUpload removes the files of the upload to fullPathWithRoot. */
+func (store *DiskStore) removeUpload(fullPathWithRoot string) error {
+	for _, suffix := range []string{offsetFileSuffix, partialFileSuffix} {
+		if err := store.FS.Remove(fullPathWithRoot + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+/* readFileFS reads the whole file at name from fsys. */
+func readFileFS(fsys FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStorageResumableWriter(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	rw, err := s.ResumableWriter("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("first half, ")); err != nil {
+		t.Fatal(err)
+	}
+	// the connection drops without Commit or Abort
+	rw.file.Close()
+
+	if offset, err := s.Offset("upload"); err != nil || offset != 12 {
+		t.Fatalf("expected offset 12, have %d (%v)", offset, err)
+	}
+	if ok, _ := s.Has("upload"); ok {
+		t.Error("expected the key to be unchanged before Commit")
+	}
+	if keys, _ := s.Keys(); len(keys) != 0 {
+		t.Errorf("expected the upload's files to be hidden, have %v", keys)
+	}
+
+	rw, err = s.ResumableWriter("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw.Offset() != 12 {
+		t.Errorf("expected to resume at 12, have %d", rw.Offset())
+	}
+	if _, err := rw.Write([]byte("second half")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := rw.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 23 {
+		t.Errorf("expected to commit 23 bytes, have %d", n)
+	}
+
+	b, err := s.ReadBytes("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "first half, second half" {
+		t.Errorf("unexpected content %q", b)
+	}
+	if offset, _ := s.Offset("upload"); offset != 0 {
+		t.Errorf("expected no upload after Commit, have offset %d", offset)
+	}
+}
+
+func TestStorageResumableWriterDiscardsUnrecordedTail(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	rw, err := s.ResumableWriter("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	// a crash between writing content and recording the offset leaves a tail
+	if _, err := rw.file.Write([]byte(" torn")); err != nil {
+		t.Fatal(err)
+	}
+	rw.file.Close()
+
+	rw, err = s.ResumableWriter("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := s.ReadBytes("upload"); !bytes.Equal(b, []byte("kept!")) {
+		t.Errorf("unexpected content %q", b)
+	}
+}
+
+func TestStorageResumableWriterAbort(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.WriteBytes("upload", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	rw, err := s.ResumableWriter("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := s.ReadBytes("upload"); string(b) != "old" {
+		t.Errorf("expected Abort to leave the key alone, have %q", b)
+	}
+	_, fullPath, _ := s.paths("upload")
+	if _, err := os.Stat(fullPath + partialFileSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the partial file to be removed, have %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+/*
+RetryOptions configures how often the opens, creates and renames of reads and
+writes are retried after transient filesystem errors, e.g. the EAGAIN and
+ESTALE an NFS mount occasionally returns.
+*/
+type RetryOptions struct {
+	/* MaxAttempts is the number of tries per operation; 0 or 1 never retries. */
+	MaxAttempts int
+
+	/* Backoff is the wait before the first retry, doubled before every further one. */
+	Backoff time.Duration
+
+	/*
+		Transient reports whether err is worth another attempt. IsTransient
+		when nil. Not-found and permission errors are never retried,
+		whatever it says.
+	*/
+	Transient func(err error) bool
+}
+
+/* IsTransient reports whether err is one of EAGAIN, ESTALE, EINTR or EBUSY. */
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.ESTALE) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EBUSY)
+}
+
+/* retry runs op until it succeeds, fails permanently or runs out of attempts. */
+func (store *DiskStore) retry(op func() error) error {
+	err := op()
+
+	backoff := store.Retry.Backoff
+	for attempt := 1; attempt < store.Retry.MaxAttempts && store.retryable(err); attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+
+		err = op()
+	}
+
+	return err
+}
+
+func (store *DiskStore) retryable(err error) bool {
+	if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+
+	if store.Retry.Transient != nil {
+		return store.Retry.Transient(err)
+	}
+	return IsTransient(err)
+}
+
+/* openRetry is FS.Open, retried according to Retry. The file holds a MaxConcurrentOps slot until it is closed. */
+func (store *DiskStore) openRetry(name string) (File, error) {
+	if err := store.slots.acquire(context.Background()); err != nil {
+		return nil, err
+	}
+
+	file, err := store.openHeld(name)
+	if err != nil {
+		store.slots.release()
+		return nil, err
+	}
+
+	return store.slots.holdUntilClose(file), nil
+}
+
+/* openHeld is openRetry for callers that already hold the slot the file is opened in. */
+func (store *DiskStore) openHeld(name string) (File, error) {
+	var file File
+	err := store.retry(func() (err error) {
+		file, err = store.FS.Open(name)
+		return err
+	})
+	return file, err
+}
+
+/* renameRetry is FS.Rename, retried according to Retry. */
+func (store *DiskStore) renameRetry(oldname, newname string) error {
+	return store.retry(func() error {
+		return store.FS.Rename(oldname, newname)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// staleFS fails the first opens and renames with ESTALE, like a flaky NFS mount.
+type staleFS struct {
+	FS
+	failures int
+	calls    int
+}
+
+func (f *staleFS) fail(name string) error {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return &os.PathError{Op: "open", Path: name, Err: syscall.ESTALE}
+	}
+	return nil
+}
+
+func (f *staleFS) Open(name string) (File, error) {
+	if err := f.fail(name); err != nil {
+		return nil, err
+	}
+	return f.FS.Open(name)
+}
+
+func (f *staleFS) Rename(oldname, newname string) error {
+	if err := f.fail(oldname); err != nil {
+		return err
+	}
+	return f.FS.Rename(oldname, newname)
+}
+
+func TestStorageRetry(t *testing.T) {
+	fsys := &staleFS{FS: OSFS{}}
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Retry:             RetryOptions{MaxAttempts: 3},
+		FS:                fsys,
+	})
+	defer teardown(t, s)
+
+	fsys.failures = 2
+	if _, err := s.Write("picture", bytes.NewReader([]byte("some jpg bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys.failures = 2
+	b, err := s.ReadBytes("picture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "some jpg bytes" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	fsys.failures = 3
+	if _, err := s.ReadBytes("picture"); !errors.Is(err, syscall.ESTALE) {
+		t.Errorf("expected %v after running out of attempts, have %v", syscall.ESTALE, err)
+	}
+
+	// not-found errors are final
+	fsys.failures, fsys.calls = 0, 0
+	if _, err := s.ReadBytes("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+	if fsys.calls != 1 {
+		t.Errorf("expected a single open of a missing key, have %d", fsys.calls)
+	}
+
+	// a classifier not considering ESTALE transient disables the retries
+	s.Retry.Transient = func(err error) bool { return false }
+	fsys.failures = 1
+	if _, err := s.ReadBytes("picture"); !errors.Is(err, syscall.ESTALE) {
+		t.Errorf("expected %v, have %v", syscall.ESTALE, err)
+	}
+}
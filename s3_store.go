@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+/* S3API is the part of the S3 client S3Store uses; *s3.Client satisfies it. */
+type S3API interface {
+	manager.UploadAPIClient
+	s3.ListObjectsV2APIClient
+
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(context.Context, *s3.DeleteObjectsInput, ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+type S3StoreOptions struct {
+	Client S3API
+	Bucket string
+
+	/* Prefix is prepended to every object key, e.g. "backups/". */
+	Prefix            string
+	PathTransformFunc PathTransformFunc
+}
+
+/*
+S3Store is a Store keeping objects in an S3 bucket. A key's FullPath, below
+Prefix, becomes the S3 object key, so the layout mirrors a DiskStore's.
+*/
+type S3Store struct {
+	S3StoreOptions
+
+	uploader *manager.Uploader
+}
+
+var _ Store = (*S3Store)(nil)
+
+func NewS3Store(options S3StoreOptions) *S3Store {
+	if options.PathTransformFunc == nil {
+		options.PathTransformFunc = DefaultPathTransformFunc
+	}
+
+	return &S3Store{
+		S3StoreOptions: options,
+		uploader:       manager.NewUploader(options.Client),
+	}
+}
+
+/*
+objectKey maps key to its S3 object key; S3 keys always use forward slashes.
+Keys whose object key would resolve outside of Prefix are rejected with
+ErrInvalidKey.
+*/
+func (store *S3Store) objectKey(key string) (string, error) {
+	pathKey := store.PathTransformFunc(key)
+	if len(pathKey.Filename) == 0 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	objectKey := path.Join(store.Prefix, pathKey.Pathname, pathKey.Filename)
+
+	// Join resolves ".." segments, which could reach the objects of another prefix
+	if prefix := path.Clean(store.Prefix); prefix != "." && prefix != "/" {
+		if !strings.HasPrefix(objectKey, prefix+"/") {
+			return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	} else if objectKey == "." || objectKey == ".." || strings.HasPrefix(objectKey, "../") || path.IsAbs(objectKey) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	return objectKey, nil
+}
+
+func (store *S3Store) Has(key string) (bool, error) {
+	_, err := store.Size(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (store *S3Store) Size(key string) (int64, error) {
+	objectKey, err := store.objectKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := store.Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return 0, wrapS3NotFound(key, err)
+	}
+
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+func (store *S3Store) Read(key string) (io.ReadCloser, error) {
+	objectKey, err := store.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := store.Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, wrapS3NotFound(key, err)
+	}
+
+	return out.Body, nil
+}
+
+/* Write streams r to S3, switching to a multipart upload for large content. */
+func (store *S3Store) Write(key string, r io.Reader) (int64, error) {
+	objectKey, err := store.objectKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	counter := &countingReader{r: r}
+	_, err = store.uploader.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(objectKey),
+		Body:   counter,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.n, nil
+}
+
+func (store *S3Store) Delete(key string) error {
+	objectKey, err := store.objectKey(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(objectKey),
+	})
+
+	return err
+}
+
+/* Keys returns the Filename of every object below Prefix. */
+func (store *S3Store) Keys() ([]string, error) {
+	keys := []string{}
+	err := store.list(func(objects []types.Object) error {
+		for _, object := range objects {
+			keys = append(keys, path.Base(aws.ToString(object.Key)))
+		}
+		return nil
+	})
+
+	return keys, err
+}
+
+/* Clear deletes every object below Prefix, one page of keys per request. */
+func (store *S3Store) Clear() error {
+	return store.list(func(objects []types.Object) error {
+		if len(objects) == 0 {
+			return nil
+		}
+
+		ids := make([]types.ObjectIdentifier, 0, len(objects))
+		for _, object := range objects {
+			ids = append(ids, types.ObjectIdentifier{Key: object.Key})
+		}
+
+		out, err := store.Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(store.Bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("could not delete %d objects, first (%s): %s",
+				len(out.Errors), aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
+		}
+
+		return nil
+	})
+}
+
+/* list calls fn with every page of objects below Prefix. */
+func (store *S3Store) list(fn func([]types.Object) error) error {
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(store.Bucket)}
+	if len(store.Prefix) > 0 {
+		input.Prefix = aws.String(strings.TrimSuffix(store.Prefix, "/") + "/")
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(store.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+		if err := fn(page.Contents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* wrapS3NotFound turns S3's not-found responses into errors matching ErrKeyNotFound. */
+func wrapS3NotFound(key string, err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey":
+			return fmt.Errorf("%w (%s): %w", ErrKeyNotFound, key, err)
+		}
+	}
+
+	return err
+}
+
+/* countingReader counts the bytes read through it. */
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 answers HeadObject from a fixed set of object keys.
+type fakeS3 struct {
+	S3API
+	objects map[string]int64
+}
+
+func (f *fakeS3) HeadObject(_ context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	size, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(size)}, nil
+}
+
+func TestS3Store(t *testing.T) {
+	pathKey := CASPathTransformFunc("picture")
+	client := &fakeS3{objects: map[string]int64{
+		"media/" + pathKey.Pathname + "/" + pathKey.Filename: 42,
+	}}
+
+	s := NewS3Store(S3StoreOptions{
+		Client:            client,
+		Bucket:            "bucket",
+		Prefix:            "media",
+		PathTransformFunc: CASPathTransformFunc,
+	})
+
+	if ok, err := s.Has("picture"); err != nil || !ok {
+		t.Errorf("expected to have picture (%v)", err)
+	}
+	if size, err := s.Size("picture"); err != nil || size != 42 {
+		t.Errorf("expected size 42, have %d (%v)", size, err)
+	}
+
+	if ok, err := s.Has("missing_key"); err != nil || ok {
+		t.Errorf("expected to NOT have missing_key (%v)", err)
+	}
+	if _, err := s.Size("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestS3StoreRejectsKeysOutsidePrefix(t *testing.T) {
+	for _, prefix := range []string{"media", "media/", ""} {
+		s := NewS3Store(S3StoreOptions{
+			Client:            &fakeS3{objects: map[string]int64{}},
+			Bucket:            "bucket",
+			Prefix:            prefix,
+			PathTransformFunc: DefaultPathTransformFunc,
+		})
+
+		for _, key := range []string{"../other-tenant/x", "a/../../x", "..", "."} {
+			if _, err := s.objectKey(key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("prefix %q, key %q: expected %v, have %v", prefix, key, ErrInvalidKey, err)
+			}
+			if _, err := s.Size(key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("prefix %q, size %q: expected %v, have %v", prefix, key, ErrInvalidKey, err)
+			}
+		}
+
+		if _, err := s.objectKey("docs/a.txt"); err != nil {
+			t.Errorf("prefix %q: expected a nested key to be valid, have %v", prefix, err)
+		}
+	}
+}
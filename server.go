@@ -25,22 +25,27 @@ type FileServer struct {
 	peerLock sync.Mutex
 	peers    map[string]p2p.Peer
 
-	storage *Storage
+	storage Store
 	quitch  chan struct{}
 }
 
-func NewFileServer(opts FileServerOptions) *FileServer {
+func NewFileServer(opts FileServerOptions) (*FileServer, error) {
 	storageOpts := StorageOptions{
 		Root:              opts.StorageRoot,
 		PathTransformFunc: opts.PathTransformFunc,
 	}
 
+	storage, err := NewDiskStore(storageOpts)
+	if err != nil {
+		return nil, err
+	}
+
 	return &FileServer{
 		FileServerOptions: opts,
-		storage:           NewStorage(storageOpts),
+		storage:           storage,
 		quitch:            make(chan struct{}),
 		peers:             make(map[string]p2p.Peer),
-	}
+	}, nil
 }
 
 func (server *FileServer) stream(msg *Message) error {
@@ -84,8 +89,12 @@ type MessageGetFile struct {
 	Key string
 }
 
-func (server *FileServer) Get(key string) (io.Reader, error) {
-	if server.storage.Has(key) {
+func (server *FileServer) Get(key string) (io.ReadCloser, error) {
+	ok, err := server.storage.Has(key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
 		return server.storage.Read(key)
 	}
 
@@ -115,8 +124,6 @@ func (server *FileServer) Get(key string) (io.Reader, error) {
 	}
 
 	select {}
-
-	return nil, nil
 }
 
 func (server *FileServer) Store(key string, r io.Reader) error {
@@ -210,7 +217,11 @@ func (server *FileServer) handleMessage(from string, msg *Message) error {
 }
 
 func (server *FileServer) handleMessageGetFile(from string, msg MessageGetFile) error {
-	if !server.storage.Has(msg.Key) {
+	ok, err := server.storage.Has(msg.Key)
+	if err != nil {
+		return err
+	}
+	if !ok {
 		return fmt.Errorf("need to serve file (%s) but it doesn't exist on disk", msg.Key)
 	}
 
@@ -220,6 +231,7 @@ func (server *FileServer) handleMessageGetFile(from string, msg MessageGetFile)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
 
 	peer, ok := server.peers[from]
 	if !ok {
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+/*
+ShardedStore spreads the objects of one logical store over several roots,
+e.g. one per mounted disk, for capacity and throughput. Every key lives on
+exactly one shard, picked from the SHA-256 of the key with jump consistent
+hashing, so callers use it like any other Store.
+
+Adding a root moves about 1/N of the keys, those jump hashing now assigns
+to the new shard, and shrinking the list moves everything from the dropped
+shards. There is no rebalancing: objects already written stay where they are
+and become unreachable under their new assignment, so they have to be moved
+by hand while no one writes, reading every key through a ShardedStore of the
+old roots and writing it through one of the new. Roots must be passed in the
+same order every time.
+*/
+type ShardedStore struct {
+	shards []*DiskStore
+}
+
+var _ Store = (*ShardedStore)(nil)
+
+/*
+NewShardedStore opens a DiskStore on each of roots, configured by options
+except for Root.
+*/
+func NewShardedStore(roots []string, options StorageOptions) (*ShardedStore, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("storage: sharded store needs at least one root")
+	}
+
+	store := &ShardedStore{}
+	for _, root := range roots {
+		options.Root = root
+		shard, err := NewDiskStore(options)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("opening shard %s: %w", root, err)
+		}
+		store.shards = append(store.shards, shard)
+	}
+
+	return store, nil
+}
+
+/* Shard returns the DiskStore holding key, e.g. for operations beyond Store. */
+func (store *ShardedStore) Shard(key string) *DiskStore {
+	sum := sha256.Sum256([]byte(key))
+	return store.shards[jumpHash(binary.BigEndian.Uint64(sum[:8]), len(store.shards))]
+}
+
+/*
+jumpHash maps key onto one of n buckets such that growing n to n+1 moves only
+the keys landing in the new bucket (Lamping and Veach, "A Fast, Minimal
+Memory, Consistent Hash Algorithm").
+*/
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func (store *ShardedStore) Has(key string) (bool, error) {
+	return store.Shard(key).Has(key)
+}
+
+func (store *ShardedStore) Size(key string) (int64, error) {
+	return store.Shard(key).Size(key)
+}
+
+func (store *ShardedStore) Read(key string) (io.ReadCloser, error) {
+	return store.Shard(key).Read(key)
+}
+
+func (store *ShardedStore) Write(key string, r io.Reader) (int64, error) {
+	return store.Shard(key).Write(key, r)
+}
+
+func (store *ShardedStore) Delete(key string) error {
+	return store.Shard(key).Delete(key)
+}
+
+/* Keys returns the keys of all shards, in lexical order. */
+func (store *ShardedStore) Keys() ([]string, error) {
+	var (
+		mu   sync.Mutex
+		keys = []string{}
+	)
+	err := store.each(func(shard *DiskStore) error {
+		shardKeys, err := shard.Keys()
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		keys = append(keys, shardKeys...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+/* Stats sums the stats of all shards. */
+func (store *ShardedStore) Stats() (StorageStats, error) {
+	var (
+		mu    sync.Mutex
+		total StorageStats
+	)
+	err := store.each(func(shard *DiskStore) error {
+		stats, err := shard.Stats()
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		total.ObjectCount += stats.ObjectCount
+		total.TotalBytes += stats.TotalBytes
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return StorageStats{}, err
+	}
+
+	return total, nil
+}
+
+/* Clear clears every shard, trying all of them even once one fails. */
+func (store *ShardedStore) Clear() error {
+	return store.each((*DiskStore).Clear)
+}
+
+/* Close closes every shard. */
+func (store *ShardedStore) Close() error {
+	var errs []error
+	for _, shard := range store.shards {
+		errs = append(errs, shard.Close())
+	}
+	return errors.Join(errs...)
+}
+
+/*
+each runs fn on every shard concurrently, as they are on different disks, and
+joins the errors, each naming the shard's root.
+*/
+func (store *ShardedStore) each(fn func(shard *DiskStore) error) error {
+	errs := make([]error, len(store.shards))
+
+	var wg sync.WaitGroup
+	for i, shard := range store.shards {
+		wg.Add(1)
+		go func(i int, shard *DiskStore) {
+			defer wg.Done()
+			if err := fn(shard); err != nil {
+				errs[i] = fmt.Errorf("shard %s: %w", shard.Root, err)
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShardedStore(t *testing.T) {
+	dir := t.TempDir()
+	roots := []string{filepath.Join(dir, "disk0"), filepath.Join(dir, "disk1"), filepath.Join(dir, "disk2")}
+
+	s, err := NewShardedStore(roots, StorageOptions{PathTransformFunc: DefaultPathTransformFunc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("object%02d", i)
+		if _, err := s.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			t.Errorf("expected objects on %s", root)
+		}
+	}
+
+	if ok, _ := s.Has("object07"); !ok {
+		t.Error("expected to have object07")
+	}
+	r, err := s.Read("object07")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "object07" {
+		t.Errorf("expected to read object07 back, have %q (%v)", b, err)
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 30 || keys[0] != "object00" || keys[29] != "object29" {
+		t.Errorf("expected all 30 keys in order, have %v", keys)
+	}
+
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ObjectCount != 30 || stats.TotalBytes != 30*8 {
+		t.Errorf("unexpected aggregated stats %+v", stats)
+	}
+
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 0 {
+		t.Errorf("expected Clear to empty all shards, have %v", keys)
+	}
+}
+
+func TestJumpHashMovesOnlyToNewBucket(t *testing.T) {
+	for key := uint64(0); key < 1000; key++ {
+		before, after := jumpHash(key*0x9e3779b97f4a7c15, 4), jumpHash(key*0x9e3779b97f4a7c15, 5)
+		if before != after && after != 4 {
+			t.Fatalf("key %d moved from %d to %d rather than to the new bucket", key, before, after)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+/*
+opSlots bounds how many objects are open for reads and writes at once, a
+counting semaphore of MaxConcurrentOps slots. A nil opSlots is unbounded.
+Slots are taken after the lock of a key, never before, so that a write waiting
+for the lock doesn't keep the slot the holder of the lock waits for.
+*/
+type opSlots chan struct{}
+
+func newOpSlots(n int) opSlots {
+	if n <= 0 {
+		return nil
+	}
+	return make(opSlots, n)
+}
+
+/* acquire waits for a free slot, or until ctx is done. */
+func (s opSlots) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s opSlots) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+/* holdUntilClose ties an acquired slot to file, releasing it once file is closed. */
+func (s opSlots) holdUntilClose(file File) File {
+	if s == nil {
+		return file
+	}
+	return &slotFile{File: file, slots: s}
+}
+
+/* slotFile releases the slot of its file on the first Close. */
+type slotFile struct {
+	File
+	slots opSlots
+	once  sync.Once
+}
+
+/* WriteTo keeps the sendfile fast path of the file, see metricsReader. */
+func (f *slotFile) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, f.File)
+}
+
+func (f *slotFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.slots.release)
+	return err
+}
+
+/* unwrapFile returns the file a slotFile holds a slot for, r itself otherwise. */
+func unwrapFile(r io.Reader) io.Reader {
+	if f, ok := r.(*slotFile); ok {
+		return f.File
+	}
+	return r
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openCountFS records the most object files, temp files included, open at once;
+// directories, sidecars and journals aren't counted.
+type openCountFS struct {
+	FS
+	mu        sync.Mutex
+	open, max int
+}
+
+func (f *openCountFS) Open(name string) (File, error) {
+	return f.track(f.FS.Open(name))
+}
+
+func (f *openCountFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return f.track(f.FS.OpenFile(name, flag, perm))
+}
+
+func (f *openCountFS) track(file File, err error) (File, error) {
+	if err != nil || strings.Contains(file.Name(), journalDirName) || strings.Contains(file.Name(), metaFileSuffix) {
+		return file, err
+	}
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		return file, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.open++
+	f.max = max(f.max, f.open)
+	return &countedFile{File: file, fs: f}, nil
+}
+
+type countedFile struct {
+	File
+	fs   *openCountFS
+	once sync.Once
+}
+
+func (f *countedFile) Close() error {
+	f.once.Do(func() {
+		f.fs.mu.Lock()
+		f.fs.open--
+		f.fs.mu.Unlock()
+	})
+	return f.File.Close()
+}
+
+func TestStorageMaxConcurrentOps(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxConcurrentOps:  1,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.WriteBytes("held", []byte("open for a while")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Read("held")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.WriteContext(ctx, "waiting", bytes.NewReader([]byte("blocked"))); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the write to wait for the open read, have %v", err)
+	}
+
+	r.Close()
+	// closing twice must not free a slot held by someone else
+	r.Close()
+
+	if _, err := s.WriteBytes("waiting", []byte("unblocked")); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.slots) != 0 {
+		t.Errorf("expected all slots to be free, have %d taken", len(s.slots))
+	}
+}
+
+func TestStorageMaxConcurrentOpsCoversOpen(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxConcurrentOps:  1,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.WriteBytes("held", []byte("open for a while")); err != nil {
+		t.Fatal(err)
+	}
+
+	file, _, err := s.Open("held")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		r, err := s.ReadAt("held", 0, 4)
+		if err == nil {
+			r.Close()
+		}
+		read <- err
+	}()
+
+	select {
+	case err := <-read:
+		t.Fatalf("expected ReadAt to wait for the open file, have %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	file.Close()
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+	if len(s.slots) != 0 {
+		t.Errorf("expected all slots to be free, have %d taken", len(s.slots))
+	}
+}
+
+func TestStorageMaxConcurrentOpsCoversWriteVariants(t *testing.T) {
+	fsys := &openCountFS{FS: OSFS{}}
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		FS:                fsys,
+		MaxConcurrentOps:  1,
+	})
+	defer teardown(t, s)
+
+	content := bytes.Repeat([]byte("slot"), 1<<12)
+	if _, err := s.WriteBytes("src", content); err != nil {
+		t.Fatal(err)
+	}
+
+	writes := []func(i int) error{
+		func(i int) error {
+			_, err := s.Write(fmt.Sprintf("write-%d", i), bytes.NewReader(content))
+			return err
+		},
+		func(i int) error {
+			_, _, err := s.WriteIfNotExists(fmt.Sprintf("new-%d", i), bytes.NewReader(content))
+			return err
+		},
+		func(i int) error {
+			_, err := s.WriteWithMeta(fmt.Sprintf("meta-%d", i), bytes.NewReader(content), map[string]string{"n": "1"})
+			return err
+		},
+		func(i int) error {
+			_, err := s.WriteCAS(fmt.Sprintf("cas-%d", i), bytes.NewReader(content), "")
+			return err
+		},
+		func(i int) error {
+			_, err := s.WriteBatch(map[string]io.Reader{fmt.Sprintf("batch-%d", i): bytes.NewReader(content)})
+			return err
+		},
+		func(i int) error {
+			txn := s.Begin()
+			if err := txn.Write(fmt.Sprintf("txn-%d", i), bytes.NewReader(content)); err != nil {
+				return err
+			}
+			return txn.Commit()
+		},
+		func(i int) error {
+			_, err := s.Copy("src", fmt.Sprintf("copy-%d", i))
+			return err
+		},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(writes))
+	for i := 0; i < 4; i++ {
+		for _, write := range writes {
+			wg.Add(1)
+			go func(i int, write func(int) error) {
+				defer wg.Done()
+				errs <- write(i)
+			}(i, write)
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a streaming Copy has its source and its temp file open in one slot
+	if fsys.max > 2*s.MaxConcurrentOps {
+		t.Errorf("expected at most %d files open at once, have %d", 2*s.MaxConcurrentOps, fsys.max)
+	}
+	if len(s.slots) != 0 {
+		t.Errorf("expected all slots to be free, have %d taken", len(s.slots))
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* ErrInsufficientSpace is returned by ReserveSpace writes whose content wouldn't fit on the disk. */
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+/*
+checkSpace fails with ErrInsufficientSpace when a write of length bytes would
+leave less than SpaceHeadroom free in any of dirs. Writes of unknown length
+are let through, as are all writes unless ReserveSpace is set.
+*/
+func (store *DiskStore) checkSpace(length int64, dirs ...string) error {
+	if !store.ReserveSpace || length < 0 {
+		return nil
+	}
+
+	for i, dir := range dirs {
+		if i > 0 && dir == dirs[i-1] {
+			continue
+		}
+
+		free, err := freeSpace(dir)
+		if err != nil {
+			return fmt.Errorf("checking free space of %s: %w", dir, err)
+		}
+
+		if needed := length + store.SpaceHeadroom; needed < 0 || uint64(needed) > free {
+			return fmt.Errorf("%w: %d bytes plus %d headroom needed in %s, %d available", ErrInsufficientSpace, length, store.SpaceHeadroom, dir, free)
+		}
+	}
+
+	return nil
+}
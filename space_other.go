@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package main
+
+import "errors"
+
+/* freeSpace fails: this platform's free space isn't queried. */
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStorageReserveSpace(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		ReserveSpace:      true,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("picture", bytes.NewReader([]byte("some jpg bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// no disk has an exbibyte to spare
+	s.SpaceHeadroom = 1 << 60
+	if _, err := s.Write("other", bytes.NewReader([]byte("some jpg bytes"))); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("expected %v, have %v", ErrInsufficientSpace, err)
+	}
+
+	// with an unknown length there is nothing to check
+	if _, err := s.Write("other", io.MultiReader(bytes.NewReader([]byte("data")))); err != nil {
+		t.Error(err)
+	}
+
+	if ok, _ := s.Has("other"); !ok {
+		t.Error("expected the unchecked write to be stored")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+statCache remembers the results of recent Has, Size and Stat lookups by object
+path, evicting the least recently used beyond capacity. Every operation
+modifying an object holds its key lock, whose release drops the object's
+entry; operations on whole trees, like Clear, purge all of them.
+*/
+type statCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+
+	// generation counts invalidations, so a lookup racing one doesn't cache what it saw before
+	generation uint64
+}
+
+/* statEntry is a cached lookup; a nil info records that there was no object. */
+type statEntry struct {
+	path string
+	info os.FileInfo
+}
+
+func newStatCache(capacity int) *statCache {
+	return &statCache{
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+/*
+get returns the cached lookup of path, if any, along with the generation to
+pass to put when the caller looks it up itself.
+*/
+func (c *statCache) get(path string) (info os.FileInfo, found bool, generation uint64) {
+	if c == nil {
+		return nil, false, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.entries[path]
+	if !found {
+		return nil, false, c.generation
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(*statEntry).info, true, c.generation
+}
+
+/* put caches info for path, unless it was invalidated since get returned generation. */
+func (c *statCache) put(path string, info os.FileInfo, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if element, ok := c.entries[path]; ok {
+		element.Value.(*statEntry).info = info
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[path] = c.order.PushFront(&statEntry{path: path, info: info})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*statEntry).path)
+	}
+}
+
+/* remove drops the entries of paths. */
+func (c *statCache) remove(paths ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, path := range paths {
+		if element, ok := c.entries[path]; ok {
+			c.order.Remove(element)
+			delete(c.entries, path)
+		}
+	}
+}
+
+/* purge drops all entries. */
+func (c *statCache) purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	clear(c.entries)
+	c.order.Init()
+}
+
+/*
+statContent is the lookup behind Has, Size and Stat: the object's file info
+with the content length as its Size, answered from the StatCache if it holds
+the key.
+*/
+func (store *DiskStore) statContent(key string) (os.FileInfo, error) {
+	_, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, found, generation := store.statCache.get(fullPathWithRoot)
+	switch {
+	case found && info == nil:
+		return nil, fmt.Errorf("%w (%s)", ErrKeyNotFound, key)
+	case found && !store.expired(info):
+		return info, nil
+	}
+
+	info, err = store.stat(key)
+	if err == nil && store.encoded() {
+		var size int64
+		size, err = store.contentSize(fullPathWithRoot, info)
+		info = objectInfo{FileInfo: info, size: size}
+	}
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			store.statCache.put(fullPathWithRoot, nil, generation)
+		}
+		return nil, err
+	}
+
+	store.statCache.put(fullPathWithRoot, info, generation)
+	return info, nil
+}
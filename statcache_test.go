@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStorageStatCache(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		StatCache:         16,
+	})
+	defer teardown(t, s)
+
+	if ok, _ := s.Has("hot"); ok {
+		t.Fatal("expected no object yet")
+	}
+	if _, err := s.WriteBytes("hot", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Has("hot"); !ok {
+		t.Error("expected the write to invalidate the cached miss")
+	}
+
+	// removed behind the store's back, the cached lookup still answers
+	_, fullPath, _ := s.paths("hot")
+	if err := os.Remove(fullPath); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := s.Size("hot"); err != nil || size != 5 {
+		t.Errorf("expected the cached size 5, have %d (%v)", size, err)
+	}
+
+	if _, err := s.WriteBytes("hot", []byte("second write")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Stat("hot"); err != nil || info.Size() != 12 {
+		t.Errorf("expected the new size 12, have %v (%v)", info, err)
+	}
+
+	if err := s.Delete("hot"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Has("hot"); ok {
+		t.Error("expected the delete to invalidate the cached hit")
+	}
+}
+
+func TestStatCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newStatCache(2)
+
+	for _, path := range []string{"a", "b"} {
+		_, _, generation := c.get(path)
+		c.put(path, nil, generation)
+	}
+	c.get("a")
+	_, _, generation := c.get("c")
+	c.put("c", nil, generation)
+
+	if _, found, _ := c.get("b"); found {
+		t.Error("expected b to be evicted")
+	}
+	for _, path := range []string{"a", "c"} {
+		if _, found, _ := c.get(path); !found {
+			t.Errorf("expected %s to be cached", path)
+		}
+	}
+
+	// a lookup racing an invalidation isn't cached
+	_, _, generation = c.get("d")
+	c.remove("a")
+	c.put("d", nil, generation)
+	if _, found, _ := c.get("d"); found {
+		t.Error("expected the stale lookup of d to be dropped")
+	}
+}
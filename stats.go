@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+/* ErrQuotaExceeded is returned by writes that would push the store past MaxBytes. */
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+/* StorageStats summarizes what a store holds. TotalBytes counts bytes on disk. */
+type StorageStats struct {
+	ObjectCount int64
+	TotalBytes  int64
+}
+
+/*
+Stats returns the object count and total size of the store. The first call
+walks the tree; afterwards running counters kept up to date by writes and
+deletes are returned, so it is cheap to call often.
+*/
+func (store *DiskStore) Stats() (StorageStats, error) {
+	store.counters.mu.Lock()
+	stats, valid := store.counters.stats, store.counters.valid
+	store.counters.mu.Unlock()
+
+	if valid {
+		return stats, nil
+	}
+
+	return store.Recount()
+}
+
+/*
+Recount walks the whole store to compute its stats and resets the running
+counters to the result, e.g. after files were changed behind the store's back.
+Namespaces share the stats of their store, so this always walks all of Root.
+*/
+func (store *DiskStore) Recount() (StorageStats, error) {
+	var stats StorageStats
+	err := store.walkDir(store.Root, func(_ string, info os.FileInfo) error {
+		stats.ObjectCount++
+		stats.TotalBytes += info.Size()
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return StorageStats{}, err
+	}
+
+	store.counters.mu.Lock()
+	store.counters.stats, store.counters.valid = stats, true
+	store.counters.mu.Unlock()
+
+	return stats, nil
+}
+
+/*
+counters are the running stats. They only start tracking once a Recount
+established a baseline; writes racing with that walk may skew them slightly.
+*/
+type counters struct {
+	mu    sync.Mutex
+	valid bool
+	stats StorageStats
+
+	// reserved are the bytes of in-flight writes
+	reserved int64
+}
+
+/* replaced accounts for an object of size bytes taking the place of old, which is nil if there was none. */
+func (c *counters) replaced(old os.FileInfo, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.valid {
+		return
+	}
+
+	if old == nil {
+		c.stats.ObjectCount++
+	} else {
+		c.stats.TotalBytes -= old.Size()
+	}
+	c.stats.TotalBytes += size
+}
+
+/* removed accounts for the object described by old going away. */
+func (c *counters) removed(old os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.valid {
+		return
+	}
+
+	c.stats.ObjectCount--
+	c.stats.TotalBytes -= old.Size()
+}
+
+/* reset marks the store as empty, e.g. after a Clear. */
+func (c *counters) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats, c.valid = StorageStats{}, true
+}
+
+/* invalidate discards the counters, the next Stats walks the store again. */
+func (c *counters) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.valid = false
+}
+
+/*
+reserve claims n bytes for an in-flight write if that keeps the store within
+max, treating credit bytes (the object being replaced) as free.
+*/
+func (c *counters) reserve(n, max, credit int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats.TotalBytes+c.reserved+n-credit > max {
+		return false
+	}
+	c.reserved += n
+
+	return true
+}
+
+/* reservedBytes returns the bytes claimed by in-flight writes. */
+func (c *counters) reservedBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.reserved
+}
+
+func (c *counters) release(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reserved -= n
+}
+
+/*
+quotaWriter fails with ErrQuotaExceeded once its writes no longer fit into
+MaxBytes. With EvictLRU it first evicts objects to make room.
+*/
+type quotaWriter struct {
+	w        io.Writer
+	counters *counters
+	max      int64
+	credit   int64
+	written  int64
+
+	// length is the total the write claims, -1 if unknown
+	length int64
+	store  *DiskStore
+}
+
+/*
+newQuotaWriter wraps w for a write of length bytes, -1 if unknown, that will
+replace the object at path, if any.
+*/
+func (store *DiskStore) newQuotaWriter(w io.Writer, path string, length int64) (*quotaWriter, error) {
+	// the running counters are the baseline the quota is checked against
+	if _, err := store.Stats(); err != nil {
+		return nil, err
+	}
+
+	var credit int64
+	if old, err := store.FS.Stat(path); err == nil {
+		credit = old.Size()
+	}
+
+	return &quotaWriter{
+		w:        w,
+		counters: store.counters,
+		max:      store.MaxBytes,
+		credit:   credit,
+		length:   length,
+		store:    store,
+	}, nil
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	if err := qw.claim(int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	return qw.w.Write(p)
+}
+
+/* claim reserves n more bytes without writing them. */
+func (qw *quotaWriter) claim(n int64) error {
+	if !qw.counters.reserve(n, qw.max, qw.credit) {
+		if !qw.store.EvictLRU || !qw.evict(n) || !qw.counters.reserve(n, qw.max, qw.credit) {
+			return ErrQuotaExceeded
+		}
+	}
+	qw.written += n
+
+	return nil
+}
+
+/*
+evict makes room for the rest of the write, or for n plus a sixteenth of
+MaxBytes when its length is unknown, so that not every further chunk has to
+evict again. It reports whether anything was evicted.
+*/
+func (qw *quotaWriter) evict(n int64) bool {
+	need := n + qw.max/16
+	if qw.length >= 0 {
+		need = max(n, qw.length-qw.written)
+	}
+
+	target := qw.max + qw.credit - qw.counters.reservedBytes() - need
+	freed, err := qw.store.evict(max(target, 0))
+	if err != nil {
+		qw.store.Logger.Error("eviction failed", "err", err)
+	}
+
+	return freed > 0
+}
+
+/* release returns the reservation once the write is accounted for or abandoned. */
+func (qw *quotaWriter) release() {
+	qw.counters.release(qw.written)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestStorageStats(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("before", bytes.NewReader([]byte("12345"))); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (StorageStats{ObjectCount: 1, TotalBytes: 5}) {
+		t.Errorf("unexpected stats after walking %+v", stats)
+	}
+
+	// running counters from here on
+	if _, err := s.Write("after", bytes.NewReader([]byte("123"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write("before", bytes.NewReader([]byte("1234567"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Copy("after", "copied"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("copied", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("after"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recounted, err := s.Recount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != recounted {
+		t.Errorf("running stats %+v drifted from recount %+v", stats, recounted)
+	}
+	if recounted != (StorageStats{ObjectCount: 2, TotalBytes: 10}) {
+		t.Errorf("unexpected stats %+v", recounted)
+	}
+}
+
+func TestStorageMaxBytes(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxBytes:          100,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("fits", bytes.NewReader(make([]byte, 60))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Write("overflows", bytes.NewReader(make([]byte, 60))); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %v, have %v", ErrQuotaExceeded, err)
+	}
+	if ok, _ := s.Has("overflows"); ok {
+		t.Error("expected the rejected write to leave nothing behind")
+	}
+
+	// replacing an object may reuse its bytes
+	if _, err := s.Write("fits", bytes.NewReader(make([]byte, 90))); err != nil {
+		t.Errorf("expected an overwrite within the quota to succeed, have %v", err)
+	}
+
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalBytes != 90 {
+		t.Errorf("expected 90 bytes in use, have %d", stats.TotalBytes)
+	}
+}
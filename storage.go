@@ -1,36 +1,180 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/cipher"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"log/slog"
+	"math/rand"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-const defaultRootFolderName = "supernetwork"
+const (
+	defaultRootFolderName = "supernetwork"
 
-func CASPathTransformFunc(key string) PathKey {
-	hash := sha1.Sum([]byte(key))
-	hashedStr := hex.EncodeToString(hash[:])
+	defaultDirMode  os.FileMode = 0755
+	defaultFileMode os.FileMode = 0644
 
-	blocksize := 5
-	slicelen := len(hashedStr) / blocksize
+	// tempFileSuffix marks in-flight writes that have not been renamed into place yet.
+	tempFileSuffix = ".tmp"
+)
+
+/*
+CASPathTransformFunc lays keys out by the SHA-1 of the key,
+split into directory blocks of 5 hex chars.
+*/
+var CASPathTransformFunc = NewCASPathTransformFunc(sha1.New, 5)
+
+/*
+NewCASPathTransformFunc returns a PathTransformFunc that hashes the key with h,
+hex-encodes the digest and splits it into directories of blocksize chars.
+The Filename is always the full hex digest.
+*/
+func NewCASPathTransformFunc(h func() hash.Hash, blocksize int) PathTransformFunc {
+	return NewCASPathTransformFuncWithOptions(h, CASOptions{BlockSize: blocksize})
+}
+
+/* CASEncoding is how a CAS PathTransformFunc spells out digests. */
+type CASEncoding int
+
+const (
+	// CASHex encodes digests as lowercase hex, 2 chars per byte.
+	CASHex CASEncoding = iota
+
+	// CASBase32 encodes digests as unpadded lowercase base32, 8 chars per 5
+	// bytes: a SHA-256 digest takes 52 chars instead of 64.
+	CASBase32
+)
+
+/* CASOptions configure NewCASPathTransformFuncWithOptions. */
+type CASOptions struct {
+	/* BlockSize is the number of digest chars per directory and must be positive. */
+	BlockSize int
+
+	/*
+		Depth limits the directories to the first Depth blocks of the digest,
+		e.g. 2 for a tree of "ab/cd/<digest>". Every block becomes a
+		directory when zero.
+	*/
+	Depth int
+
+	Encoding CASEncoding
+}
+
+var base32Encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+/*
+NewCASPathTransformFuncWithOptions is NewCASPathTransformFunc with control over
+the depth of the directory tree and the encoding of the digest, e.g. to keep
+the inodes and filename lengths of SHA-256 digests down. The Filename is
+always the full encoded digest.
+*/
+func NewCASPathTransformFuncWithOptions(h func() hash.Hash, opts CASOptions) PathTransformFunc {
+	if opts.BlockSize <= 0 {
+		panic("storage: CAS blocksize must be positive")
+	}
+	if opts.Depth < 0 {
+		panic("storage: CAS depth must not be negative")
+	}
 
-	paths := make([]string, slicelen)
-	for i := range slicelen {
-		from, to := i*blocksize, (i*blocksize)+blocksize
-		paths[i] = hashedStr[from:to]
+	encode := hex.EncodeToString
+	if opts.Encoding == CASBase32 {
+		encode = base32Encoding.EncodeToString
 	}
 
-	return PathKey{
-		Pathname: strings.Join(paths, "/"),
-		Filename: hashedStr,
+	return func(key string) PathKey {
+		hasher := h()
+		hasher.Write([]byte(key))
+		hashedStr := encode(hasher.Sum(nil))
+
+		blocks := splitBlocks(hashedStr, opts.BlockSize)
+		if opts.Depth > 0 && opts.Depth < len(blocks) {
+			blocks = blocks[:opts.Depth]
+		}
+
+		return PathKey{
+			Pathname: strings.Join(blocks, "/"),
+			Filename: hashedStr,
+		}
+	}
+}
+
+/*
+NewDigestPathTransformFunc returns a PathTransformFunc for content-addressed
+stores, where every key already is the hex digest of its content. The key is
+split into directories of blocksize chars and kept verbatim as the Filename.
+*/
+func NewDigestPathTransformFunc(blocksize int) PathTransformFunc {
+	if blocksize <= 0 {
+		panic("storage: digest blocksize must be positive")
+	}
+
+	return func(key string) PathKey {
+		return PathKey{
+			Pathname: strings.Join(splitBlocks(key, blocksize), "/"),
+			Filename: key,
+		}
+	}
+}
+
+/* splitBlocks cuts s into blocks of blocksize, keeping a shorter trailing block. */
+func splitBlocks(s string, blocksize int) []string {
+	blocks := make([]string, 0, (len(s)+blocksize-1)/blocksize)
+	for from := 0; from < len(s); from += blocksize {
+		to := min(from+blocksize, len(s))
+		blocks = append(blocks, s[from:to])
+	}
+	return blocks
+}
+
+/* ErrKeyNotFound is returned when no object is stored under the requested key. */
+var ErrKeyNotFound = errors.New("key not found")
+
+/* ErrCorrupted is returned when stored content no longer matches its digest. */
+var ErrCorrupted = errors.New("content corrupted")
+
+/* ErrNotContentAddressed is returned by integrity checks on stores without ContentAddressed. */
+var ErrNotContentAddressed = errors.New("store is not content-addressed")
+
+/* ErrObjectTooLarge is returned by writes whose content exceeds MaxObjectBytes. */
+var ErrObjectTooLarge = errors.New("object too large")
+
+/* ErrKeyExists is returned by WriteIfNotExists when key already holds an object. */
+var ErrKeyExists = errors.New("key already exists")
+
+/* ErrReadOnly is returned by every operation that would modify a ReadOnly store. */
+var ErrReadOnly = errors.New("store is read-only")
+
+/* ErrInvalidKey is returned for keys whose path would resolve outside of the storage root. */
+var ErrInvalidKey = errors.New("invalid key")
+
+/*
+wrapNotFound turns a not-exist filesystem error into one matching ErrKeyNotFound,
+keeping the underlying error in the chain. Other errors are returned untouched.
+*/
+func wrapNotFound(key string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w (%s): %w", ErrKeyNotFound, key, err)
 	}
+	return err
 }
 
 type PathTransformFunc func(string) PathKey
@@ -40,6 +184,12 @@ type PathKey struct {
 	Filename string
 }
 
+/*
+FirstPathname returns the top directory of the Pathname, which for
+DefaultPathTransformFunc is the first segment of the key. Other keys may be
+stored below it, so it must not be removed on behalf of a single key: Delete
+only ever removes the object's own file.
+*/
 func (p PathKey) FirstPathname() string {
 	paths := strings.Split(p.Pathname, "/")
 	if len(paths) == 0 {
@@ -49,7 +199,7 @@ func (p PathKey) FirstPathname() string {
 }
 
 func (p PathKey) FullPath() string {
-	return fmt.Sprintf("%s/%s", p.Pathname, p.Filename)
+	return filepath.Join(p.Pathname, p.Filename)
 }
 
 var DefaultPathTransformFunc = func(key string) PathKey {
@@ -62,97 +212,2006 @@ var DefaultPathTransformFunc = func(key string) PathKey {
 type StorageOptions struct {
 	/*
 		Root is the folder name of the root,
-		containing all the folders / files of the system
+		containing all the folders / files of the system.
+		NewDiskStore replaces it with its cleaned absolute path, resolving
+		a relative Root against the working directory at that time.
 	*/
 	Root              string
 	PathTransformFunc PathTransformFunc
+
+	/*
+		DirMode and FileMode are the permissions for created directories
+		and stored files, before the process umask. Default to 0755 / 0644.
+	*/
+	DirMode  os.FileMode
+	FileMode os.FileMode
+
+	/*
+		PruneEmptyDirs makes Delete remove the directories left empty by the
+		deleted object, walking up towards (but never removing) Root.
+	*/
+	PruneEmptyDirs bool
+
+	/*
+		Compression compresses objects at rest; reads decompress transparently.
+		CompressionNone by default.
+	*/
+	Compression Compression
+
+	/*
+		CompressMinBytes leaves objects smaller than this uncompressed, as
+		gzip only grows tiny ones. Their envelope header tells reads that
+		the body is stored raw. Up to this many bytes of every write are
+		buffered to decide.
+	*/
+	CompressMinBytes int
+
+	/*
+		EncryptionKey, when set, encrypts objects at rest with AES-256-GCM
+		and must be 32 bytes long. See encryption.go for the format.
+	*/
+	EncryptionKey []byte
+
+	/*
+		MaxBytes caps the total bytes on disk; 0 means unlimited. Since
+		incoming streams have no known length, a write is aborted with
+		ErrQuotaExceeded as soon as it would cross the limit while copying,
+		and its partial file is removed. Bytes of the object being replaced
+		count as free. Only objects count, as in Stats: kept revisions,
+		metadata and refs sidecars, the trash and the store's own files
+		below Root take space on top, so with MaxVersions the disk usage can
+		reach MaxVersions+1 times MaxBytes.
+	*/
+	MaxBytes int64
+
+	/*
+		ReserveSpace makes writes of content with a known length, e.g. from a
+		bytes.Reader or a regular file, check the free space of the disk
+		first and fail fast with ErrInsufficientSpace rather than running out
+		of it midway. SpaceHeadroom bytes must remain free on top of the
+		content. The check queries the OS (statfs, GetDiskFreeSpaceEx)
+		directly, bypassing FS, and fails on platforms without support.
+	*/
+	ReserveSpace  bool
+	SpaceHeadroom int64
+
+	/*
+		EvictLRU turns the store into a cache bounded by MaxBytes: instead of
+		failing with ErrQuotaExceeded, a write that doesn't fit first evicts
+		the least recently used objects, those with the oldest modification
+		time, until it does. Reads don't count as use; Touch objects to keep
+		them. See Evict.
+	*/
+	EvictLRU bool
+
+	/*
+		TTL makes objects expire once their modification time is longer ago:
+		Read, Has and the other lookups treat them as absent and remove them
+		on the way, while ExpireNow sweeps the whole store. Every write and
+		Touch starts the window over, but a Copy sharing its source's file
+		through a hardlink keeps the source's age. Zero means objects never
+		expire.
+	*/
+	TTL time.Duration
+
+	/* MaxMapBytes caps the content length of objects MapRead maps; 0 means unlimited. */
+	MaxMapBytes int64
+
+	/*
+		ReadBufferSize makes Read and the streams of other reads buffer the
+		object's content in chunks of this many bytes, so many small reads
+		cost one read of the file each time the buffer runs dry rather than
+		one each, e.g. on NFS. The streams are then *BufferedReadCloser.
+		Unbuffered when zero.
+	*/
+	ReadBufferSize int
+
+	/*
+		DetectContentType sniffs the MIME type of every write from its first
+		512 bytes with http.DetectContentType and keeps it in the object's
+		metadata under ContentTypeMetaKey, for ContentType and Handler.
+		Metadata given to WriteWithMeta takes precedence.
+	*/
+	DetectContentType bool
+
+	/* MaxObjectBytes caps the content length of a single object; 0 means unlimited. */
+	MaxObjectBytes int64
+
+	/* Hash computes content digests, e.g. for WriteWithHash. SHA-256 by default. */
+	Hash func() hash.Hash
+
+	/*
+		ContentAddressed declares that every key is the hex digest of its
+		content under Hash and that PathTransformFunc keeps it as the Filename,
+		as NewDigestPathTransformFunc does. It enables integrity verification.
+	*/
+	ContentAddressed bool
+
+	/*
+		VerifyReads makes the streams of Read and its variants on a
+		ContentAddressed store hash the content as it is read and fail the
+		Read that reaches the end with ErrCorrupted, in place of io.EOF, when
+		the digest doesn't match the key. See verifyingReader.
+	*/
+	VerifyReads bool
+
+	/*
+		Sync makes writes durable before they return: the content is fsynced
+		before the rename and the directory holding the object after it, so a
+		power loss can't take back a successful write. This costs two fsyncs
+		per write, easily slowing small writes down by an order of magnitude.
+	*/
+	Sync bool
+
+	/*
+		TempDir is where writes stream their content before it is renamed into
+		place; next to the object when empty. On another filesystem than Root
+		the finished content is copied over into a temp file beside the object
+		first, so the final rename still never exposes a partial file.
+	*/
+	TempDir string
+
+	/*
+		Trash makes Delete move objects into a trash directory below Root
+		instead of removing them, so Restore can bring them back until
+		EmptyTrash is called. Trashed objects don't count towards Stats.
+	*/
+	Trash bool
+
+	/*
+		MaxVersions keeps up to this many previous revisions of an object when
+		it is overwritten, by a write, Copy or Move, readable with
+		ReadVersion. Ignored when
+		ContentAddressed, since such objects never change.
+	*/
+	MaxVersions int
+
+	/*
+		RefCounting counts the writes storing an object, so that on a
+		ContentAddressed store each owner of deduplicated content can write
+		and delete it independently: Delete only removes the object once its
+		last reference is gone.
+	*/
+	RefCounting bool
+
+	/*
+		GCGracePeriod is how old temp files and empty directories must be
+		before GC removes them, so it doesn't race in-flight writes. One hour
+		when zero.
+	*/
+	GCGracePeriod time.Duration
+
+	/*
+		Progress is called every MiB or so while Write, Export and SyncTo copy
+		bytes, and once more when they are done. bytesTotal is -1 when the
+		length isn't known up front, e.g. for a Write from a network stream.
+	*/
+	Progress func(bytesDone, bytesTotal int64)
+
+	/*
+		ReadOnly makes every operation that would modify the store fail with
+		ErrReadOnly before touching the filesystem, e.g. for a shared
+		read-only mount. Root is not created either.
+	*/
+	ReadOnly bool
+
+	/*
+		OnWrite and OnDelete are called after a write or delete of key
+		succeeded, never for failed ones. They run synchronously on the
+		caller's goroutine once the key is unlocked, so they may use the store
+		but delay the operation's return until they are done. Copy and Move
+		report the destination as written, Move the source as deleted too.
+	*/
+	OnWrite  func(key string, size int64)
+	OnDelete func(key string)
+
+	/*
+		Retry retries opening, creating and renaming the files of reads and
+		writes after transient errors. Nothing is retried by default.
+	*/
+	Retry RetryOptions
+
+	/*
+		ProcessLock coordinates processes sharing Root, e.g. workers on one
+		NFS mount: every modification of a key additionally holds an advisory
+		lock (flock, LockFileEx on Windows) on a lock file below Root, and
+		opening a root another process already has open logs a warning. The
+		lock files bypass FS, so Root has to be on the OS filesystem. Ignored
+		when ReadOnly.
+	*/
+	ProcessLock bool
+
+	/*
+		MaxConcurrentOps caps how many objects are open at once, staying
+		clear of the file descriptor limit under load: opening an object
+		file, its metadata or a kept revision takes a slot until the file is
+		closed, and so does the temp file of every write, whether by Write,
+		its variants, WriteBatch, a Txn or Copy. A streaming Copy reads its
+		source in the slot of its write. Further ones wait for a slot; a
+		write's context ends the wait. Streams that are never closed
+		eventually block every read and write, and writing a stream of the
+		same store takes two slots, so as many such copies as there are slots
+		deadlock. Append, OpenReadWrite and the sidecars and directories a
+		write syncs or replaces go without a slot. Files handed out by Open
+		are wrapped, so copying a range of one, as http.ServeContent does,
+		goes without sendfile. Unbounded when zero.
+	*/
+	MaxConcurrentOps int
+
+	/*
+		BatchWorkers bounds the goroutines of a batch operation, and those
+		of all WriteAsync calls together. GOMAXPROCS when zero.
+	*/
+	BatchWorkers int
+
+	/*
+		ParallelClear makes Clear remove the top-level directories below Root
+		on BatchWorkers goroutines, which is much faster for stores of
+		millions of files on filesystems with slow unlinks.
+	*/
+	ParallelClear bool
+
+	/* Metrics observes writes, reads and deletes. Nothing is recorded when nil. */
+	Metrics Metrics
+
+	/*
+		CaseInsensitiveFS declares that Root is on a filesystem folding case,
+		like the defaults of macOS and Windows. Paths are then escaped so that
+		keys differing in case never share a file; see casefold.go. Writable
+		stores detect it on creation by probing Root with a temp file, so it
+		only needs to be set for ReadOnly ones. Stores of the
+		DefaultPathTransformFunc holding mixed-case keys from before the escape
+		have to be rewritten, CAS stores are not affected.
+	*/
+	CaseInsensitiveFS bool
+
+	/*
+		Dedup makes writes store content already held by another key as a
+		hardlink to it instead of a second copy, looking it up by its digest
+		under Hash in an index below Root. Deleting or overwriting one key
+		leaves the others sharing its content intact. Shared files also
+		share their modification time, which a Touch or a write of the same
+		content refreshes for all of them, and OpenReadWrite and Append are
+		not supported. Stats and MaxBytes still count every key in full.
+		Needs an FS implementing Linker and a TempDir, if any, on the same
+		filesystem as Root; ignored when ContentAddressed.
+	*/
+	Dedup bool
+
+	/*
+		DryRun makes Clear, GC and DeletePrefix leave the store alone and
+		instead log every file and directory they would remove, with its
+		size, to Logger at Info level. OnDelete and Metrics see nothing, as
+		nothing is deleted. Preview runs them in dry-run mode per call and
+		also returns the list.
+	*/
+	DryRun bool
+
+	/*
+		StatCache keeps the results of up to this many recent Has, Size and
+		Stat lookups in memory, including those finding no object. Changes
+		made through this store and its namespaces invalidate them, changes
+		by other processes or behind the store's back don't, so keep it at
+		the default 0, disabled, when Root is shared.
+	*/
+	StatCache int
+
+	/*
+		BloomFilter keeps the paths of all objects in an in-memory Bloom
+		filter, so that Has answers for most absent keys without touching the
+		filesystem. Creating the store walks Root once to fill it. It only
+		learns about objects added through this store and its namespaces, so
+		it must not be used when other processes write to Root. See bloom.go
+		for its false-positive rate and memory cost.
+	*/
+	BloomFilter bool
+
+	/*
+		ChecksumIndex keeps the digest, size and last verification of every
+		object written in an index below Root, so that Verify scrubs the
+		objects verified longest ago first and VerifyIndex can check sizes
+		without reading any content. See checksums.go.
+	*/
+	ChecksumIndex bool
+
+	/* StrictDelete makes Delete report ErrKeyNotFound for keys that are not stored. */
+	StrictDelete bool
+
+	/* Logger receives the storage's log output. Discarded when nil. */
+	Logger *slog.Logger
+
+	/* FS is the filesystem objects are kept on. OSFS when nil. */
+	FS FS
 }
 
-type Storage struct {
+/*
+DiskStore is the Store keeping objects as files below Root on the local disk.
+
+It is safe for concurrent use. Writes, deletes, copies and moves touching
+the same key are serialized, while operations on different keys run in
+parallel. Reads never block: thanks to the atomic rename they observe either
+the previous or the new content of a key, never a mix of both.
+*/
+type DiskStore struct {
 	StorageOptions
+
+	aead     cipher.AEAD
+	locks    *keyLocks
+	counters *counters
+
+	// prefix is the directory below Root a Namespace view is confined to
+	prefix string
+
+	// rootLock holds the shared root lock of a ProcessLock store until Close
+	rootLock *os.File
+
+	// bloom holds the object paths of a BloomFilter store, nil otherwise
+	bloom *bloomFilter
+
+	// checksums is the index of a ChecksumIndex store, nil otherwise
+	checksums *checksumIndex
+
+	// statCache holds recent lookups when StatCache is set, nil otherwise
+	statCache *statCache
+
+	// dryRun collects what a Preview view would remove
+	dryRun *dryRun
+
+	// slots bounds the open objects of a MaxConcurrentOps store, nil otherwise
+	slots opSlots
+
+	// asyncSlots bounds the goroutines of WriteAsync
+	asyncSlots opSlots
+
+	// readPools recycle the buffers of a ReadBufferSize store, nil otherwise
+	readPools *readPools
 }
 
-func NewStorage(options StorageOptions) *Storage {
+/* Storage is the former name of DiskStore, kept for existing callers. */
+type Storage = DiskStore
+
+/* NewStorage is the former name of NewDiskStore, kept for existing callers. */
+func NewStorage(options StorageOptions) (*Storage, error) {
+	return NewDiskStore(options)
+}
+
+/*
+NewDiskStore applies the option defaults and creates the root directory,
+so a misconfigured or unwritable Root fails here rather than on first use.
+*/
+func NewDiskStore(options StorageOptions) (*DiskStore, error) {
 	if options.PathTransformFunc == nil {
 		options.PathTransformFunc = DefaultPathTransformFunc
 	}
 	if len(options.Root) == 0 {
 		options.Root = defaultRootFolderName
 	}
+	// "data/", "./data" and "data" are all the same root, wherever the process chdirs to later
+	root, err := filepath.Abs(options.Root)
+	if err != nil {
+		return nil, err
+	}
+	options.Root = root
+	if len(options.TempDir) > 0 {
+		if options.TempDir, err = filepath.Abs(options.TempDir); err != nil {
+			return nil, err
+		}
+	}
+	if options.DirMode == 0 {
+		options.DirMode = defaultDirMode
+	}
+	if options.FileMode == 0 {
+		options.FileMode = defaultFileMode
+	}
+	if options.Hash == nil {
+		options.Hash = sha256.New
+	}
+	if options.Logger == nil {
+		options.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if options.FS == nil {
+		options.FS = OSFS{}
+	}
+	if options.Metrics == nil {
+		options.Metrics = nopMetrics{}
+	}
+	if options.GCGracePeriod == 0 {
+		options.GCGracePeriod = defaultGCGracePeriod
+	}
 
-	return &Storage{
+	store := &DiskStore{
 		StorageOptions: options,
+		locks:          &keyLocks{},
+		counters:       &counters{},
+		slots:          newOpSlots(options.MaxConcurrentOps),
+	}
+	store.asyncSlots = newOpSlots(store.workers())
+
+	if options.ReadBufferSize > 0 {
+		store.readPools = newReadPools(options.ReadBufferSize)
+	}
+
+	if options.EncryptionKey != nil {
+		aead, err := newAEAD(options.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		store.aead = aead
+	}
+
+	if options.StatCache > 0 {
+		store.statCache = newStatCache(options.StatCache)
+		store.locks.cache = store.statCache
+	}
+
+	if options.BloomFilter {
+		if err := store.loadBloomFilter(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.ChecksumIndex {
+		if err := store.loadChecksumIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.ReadOnly {
+		store.foldCase()
+		return store, nil
+	}
+
+	if err := options.FS.MkdirAll(options.Root, options.DirMode); err != nil {
+		return nil, err
+	}
+	if len(options.TempDir) > 0 {
+		if err := options.FS.MkdirAll(options.TempDir, options.DirMode); err != nil {
+			return nil, err
+		}
+	}
+
+	if !options.CaseInsensitiveFS {
+		insensitive, err := store.probeCaseInsensitive()
+		if err != nil {
+			return nil, err
+		}
+		store.CaseInsensitiveFS = insensitive
+	}
+	store.foldCase()
+
+	if options.ProcessLock {
+		if err := store.lockRoot(); err != nil {
+			return nil, err
+		}
+		store.locks.dir = store.locksRoot()
+		store.locks.logger = options.Logger
+	}
+
+	if err := store.recoverJournals(); err != nil {
+		store.Close()
+		return nil, err
 	}
+
+	return store, nil
 }
 
-func (store *Storage) Has(key string) bool {
-	pathKey := store.PathTransformFunc(key)
+/*
+Has reports whether an object is stored under key. A missing key yields
+(false, nil); any other stat failure is returned as an error.
+*/
+func (store *DiskStore) Has(key string) (bool, error) {
+	if _, fullPathWithRoot, err := store.paths(key); err == nil && !store.bloom.mayContain(fullPathWithRoot) {
+		return false, nil
+	}
+
+	_, err := store.statContent(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		store.expire(key, err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
 
-	fullPathWithRoot := fmt.Sprintf("%s/%s", store.Root, pathKey.FullPath())
-	_, err := os.Stat(fullPathWithRoot)
+	return true, nil
+}
+
+/*
+Size returns the size in bytes of the object stored under key. For compressed
+objects this is the uncompressed length, read from the object's header.
+*/
+func (store *DiskStore) Size(key string) (int64, error) {
+	info, err := store.statContent(key)
+	if err != nil {
+		return 0, err
+	}
 
-	return !errors.Is(err, os.ErrNotExist)
+	return info.Size(), nil
 }
 
-func (s *Storage) Clear() error {
-	return os.RemoveAll(s.Root)
+/*
+Stat returns the file info of the object stored under key, e.g. for its
+modification time, or ErrKeyNotFound. Like Size, the info's Size is the content
+length, also for compressed or encrypted objects.
+*/
+func (store *DiskStore) Stat(key string) (os.FileInfo, error) {
+	return store.statContent(key)
 }
 
-func (store *Storage) Delete(key string) error {
-	pathKey := store.PathTransformFunc(key)
+/*
+Touch sets the access and modification times of the object stored under key to
+now without rewriting it, e.g. to mark it as recently used for an eviction
+policy. A missing key yields ErrKeyNotFound.
+*/
+func (store *DiskStore) Touch(key string) error {
+	if err := store.writable(); err != nil {
+		return err
+	}
 
-	defer func() {
-		log.Printf("deleted [%s] from disk", pathKey.Filename)
-	}()
+	_, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return err
+	}
 
-	firstPathnameWithRoot := fmt.Sprintf("%s/%s", store.Root, pathKey.FirstPathname())
+	defer store.locks.lock(fullPathWithRoot)()
 
-	return os.RemoveAll(firstPathnameWithRoot)
-}
+	now := time.Now()
+	if err := store.FS.Chtimes(fullPathWithRoot, now, now); err != nil {
+		return wrapNotFound(key, err)
+	}
 
-func (store *Storage) Write(key string, r io.Reader) (int64, error) {
-	return store.writeStream(key, r)
+	return nil
 }
 
-func (store *Storage) Read(key string) (io.Reader, error) {
-	file, err := store.readStream(key)
+/* stat returns the file info of the stored object, or ErrKeyNotFound when it is absent or expired. */
+func (store *DiskStore) stat(key string) (os.FileInfo, error) {
+	_, fullPathWithRoot, err := store.paths(key)
 	if err != nil {
 		return nil, err
 	}
 
-	defer file.Close()
+	info, err := store.FS.Stat(fullPathWithRoot)
+	if err != nil {
+		return nil, wrapNotFound(key, err)
+	}
+	if store.expired(info) {
+		return nil, expiredError(key)
+	}
 
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, file)
+	return info, nil
+}
+
+/*
+Walk calls fn with the Filename and size of every stored object.
+In-flight temp files are skipped. Returning an error from fn stops the walk.
+*/
+func (store *DiskStore) Walk(fn func(filename string, size int64) error) error {
+	err := store.walkDir(filepath.Join(store.Root, store.prefix), func(_ string, info os.FileInfo) error {
+		return fn(store.filenameOf(info.Name()), info.Size())
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 
-	return buf, err
+	return err
 }
 
-func (store *Storage) readStream(key string) (io.ReadCloser, error) {
-	pathKey := store.PathTransformFunc(key)
-	fullPathWithRoot := fmt.Sprintf("%s/%s", store.Root, pathKey.FullPath())
-	return os.Open(fullPathWithRoot)
+/* walkDir calls fn with the path and info of the objects in dir and its subdirectories, in lexical order. */
+func (store *DiskStore) walkDir(dir string, fn func(path string, info os.FileInfo) error) error {
+	d, err := store.FS.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	infos, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	for _, info := range infos {
+		switch {
+		case info.IsDir():
+			sub := filepath.Join(dir, info.Name())
+			if store.reservedDir(sub) {
+				continue
+			}
+
+			err := store.walkDir(sub, fn)
+			// a directory pruned while we walk simply holds no objects anymore
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		case info.Mode().IsRegular() && isObjectFile(info.Name()):
+			if err := fn(filepath.Join(dir, info.Name()), info); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
-func (store *Storage) writeStream(key string, r io.Reader) (int64, error) {
-	pathKey := store.PathTransformFunc(key)
+/* Keys returns the Filename of every stored object. */
+func (store *DiskStore) Keys() ([]string, error) {
+	keys := []string{}
+	err := store.Walk(func(filename string, _ int64) error {
+		keys = append(keys, filename)
+		return nil
+	})
 
-	pathnameWithRoot := fmt.Sprintf("%s/%s", store.Root, pathKey.Pathname)
-	if err := os.MkdirAll(pathnameWithRoot, os.ModePerm); err != nil {
-		return 0, err
+	return keys, err
+}
+
+/*
+KeysWithPrefix returns the keys starting with prefix, in lexical order. Only
+the directory the prefix transforms into is walked, not the whole store. It
+needs a PathTransformFunc that keeps keys readable and uses the key as the
+Filename, like DefaultPathTransformFunc; objects whose key can't be told from
+their path, such as those of CASPathTransformFunc, are never listed.
+*/
+func (store *DiskStore) KeysWithPrefix(prefix string) ([]string, error) {
+	start := store.PathTransformFunc(prefix).Pathname
+	if !strings.HasSuffix(prefix, "/") {
+		// the prefix may end within a path element, e.g. "docs/re" for "docs/report"
+		start = path.Dir(start)
 	}
 
-	fullPathWithRoot := fmt.Sprintf("%s/%s", store.Root, pathKey.FullPath())
+	dir := filepath.Join(store.Root, start)
+	if dir != store.Root && !store.insideRoot(dir) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidKey, prefix)
+	}
 
-	file, err := os.Create(fullPathWithRoot)
+	keys := []string{}
+	err := store.walkDir(dir, func(objectPath string, _ os.FileInfo) error {
+		key, ok := store.keyOf(objectPath)
+		if ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+/*
+keyOf recovers the key stored at objectPath for transforms using the key as
+the Filename: the key is the trailing part of the path that transforms back
+into objectPath.
+*/
+func (store *DiskStore) keyOf(objectPath string) (string, bool) {
+	rel, err := filepath.Rel(store.Root, objectPath)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+
+	for i := len(rel) - 1; i >= 0; i-- {
+		if i > 0 && rel[i-1] != '/' {
+			continue
+		}
+
+		key := store.filenameOf(rel[i:])
+		if filepath.ToSlash(store.PathTransformFunc(key).FullPath()) == rel {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+/*
+Clear removes every object from the store, leaving an empty Root directory in
+place. On a Namespace only the namespace's objects are removed. When dry
+running, the store is left as it is.
+*/
+func (s *DiskStore) Clear() error {
+	if err := s.writable(); err != nil {
+		return err
 	}
 
-	n, err := io.Copy(file, r)
+	dir := filepath.Join(s.Root, s.prefix)
+
+	root, err := s.FS.Open(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		if s.dryRunning() {
+			return nil
+		}
+		return s.FS.MkdirAll(dir, s.DirMode)
+	}
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	return n, nil
+	entries, err := root.Readdir(-1)
+	root.Close()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// other processes may be waiting on the lock files
+		if path := filepath.Join(dir, entry.Name()); path != s.locksRoot() {
+			paths = append(paths, path)
+		}
+	}
+
+	// every entry is tried even once one fails, leaving no stragglers behind it
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	remove := func(i int) {
+		if err := s.removeAll(paths[i]); err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	}
+	if s.ParallelClear {
+		s.parallel(len(paths), remove)
+	} else {
+		for i := range paths {
+			remove(i)
+		}
+	}
+	if s.dryRunning() {
+		return errors.Join(errs...)
+	}
+	s.statCache.purge()
+	if len(s.prefix) == 0 {
+		s.checksums.reset()
+	} else {
+		s.checksums.forgetDir(dir)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		s.counters.invalidate()
+		return err
+	}
+
+	// the counters cover the whole store, other namespaces still hold objects
+	if len(s.prefix) == 0 {
+		s.counters.reset()
+	} else {
+		s.counters.invalidate()
+	}
+
+	return nil
+}
+
+/*
+Delete removes the object stored under key. Deleting a missing key is a no-op
+unless StrictDelete is set, in which case it returns ErrKeyNotFound.
+*/
+func (store *DiskStore) Delete(key string) error {
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := store.deleteKey(key, store.PruneEmptyDirs)
+	store.Metrics.ObserveDelete(time.Since(start), err)
+	if err != nil {
+		return err
+	}
+	store.deleted(key)
+
+	return nil
+}
+
+/* deleteKey implements Delete, leaving the pruning of emptied directories to the caller unless prune is set. */
+func (store *DiskStore) deleteKey(key string, prune bool) error {
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return err
+	}
+
+	defer store.locks.lock(fullPathWithRoot)()
+
+	if store.StrictDelete {
+		if _, err := store.stat(key); err != nil {
+			return err
+		}
+	}
+
+	return store.deleteLocked(key, pathnameWithRoot, fullPathWithRoot, prune)
+}
+
+/*
+deleteLocked is deleteKey for callers that hold the lock of fullPathWithRoot
+and did any StrictDelete check themselves.
+*/
+func (store *DiskStore) deleteLocked(key, pathnameWithRoot, fullPathWithRoot string, prune bool) error {
+	info, err := store.FS.Stat(fullPathWithRoot)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if info != nil && store.RefCounting {
+		n, err := store.refs(fullPathWithRoot)
+		if err != nil {
+			return err
+		}
+		if n > 1 {
+			store.Logger.Info("dropped reference", "key", key, "path", fullPathWithRoot, "refs", n-1)
+			return store.setRefs(pathnameWithRoot, fullPathWithRoot, n-1)
+		}
+		if err := store.removeRefs(fullPathWithRoot); err != nil {
+			return err
+		}
+	}
+
+	if store.Trash && info != nil {
+		if err := store.trash(fullPathWithRoot); err != nil {
+			store.Logger.Error("delete failed", "key", key, "path", fullPathWithRoot, "err", err)
+			return err
+		}
+		store.counters.removed(info)
+		store.checksums.forget(fullPathWithRoot)
+	} else {
+		// Only the object's own file goes; sibling keys may share its directories.
+		if err := store.FS.Remove(fullPathWithRoot); err != nil && !errors.Is(err, os.ErrNotExist) {
+			store.Logger.Error("delete failed", "key", key, "path", fullPathWithRoot, "err", err)
+			return err
+		}
+		if info != nil {
+			store.counters.removed(info)
+			store.checksums.forget(fullPathWithRoot)
+		}
+
+		if err := store.removeMeta(fullPathWithRoot); err != nil {
+			return err
+		}
+		if err := store.removeVersions(fullPathWithRoot); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	store.Logger.Info("deleted from disk", "key", key, "path", fullPathWithRoot)
+
+	if prune {
+		store.pruneEmptyDirs(pathnameWithRoot)
+	}
+
+	return nil
+}
+
+/*
+pruneEmptyDirs removes dir and its parents while they are empty,
+stopping at the first non-empty directory and never removing Root.
+*/
+func (store *DiskStore) pruneEmptyDirs(dir string) {
+	for store.insideRoot(dir) {
+		// Remove refuses non-empty directories, which is our stop condition
+		if err := store.FS.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (store *DiskStore) Write(key string, r io.Reader) (int64, error) {
+	return store.WriteContext(context.Background(), key, r)
+}
+
+/*
+WriteWithHash is like Write but also returns the hex digest of the content
+under the configured Hash, computed while the bytes are copied to disk.
+*/
+func (store *DiskStore) WriteWithHash(key string, r io.Reader) (int64, string, error) {
+	hasher := store.Hash()
+
+	result, err := store.writeStream(context.Background(), key, io.TeeReader(r, hasher))
+	if err != nil {
+		return 0, "", err
+	}
+
+	return result.Bytes, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+/*
+WriteContext is like Write but aborts the copy once ctx is cancelled or its
+deadline passes, removing the partially written file.
+*/
+func (store *DiskStore) WriteContext(ctx context.Context, key string, r io.Reader) (int64, error) {
+	result, err := store.writeStream(ctx, key, r)
+	return result.Bytes, err
+}
+
+/* WriteResult describes the outcome of a write. */
+type WriteResult struct {
+	Bytes int64
+
+	/* Created is set when key held no object before, Overwritten when it did. */
+	Created     bool
+	Overwritten bool
+
+	/* ContentType is the sniffed MIME type of the content with DetectContentType. */
+	ContentType string
+
+	/* Err is the error of a WriteAsync; the other writes return theirs as usual. */
+	Err error
+}
+
+/*
+WriteReport is like Write but also reports whether the write created key or
+replaced its object. This is decided right before the atomic rename, while
+the key is locked, so concurrent writes can't both claim to have created it.
+*/
+func (store *DiskStore) WriteReport(key string, r io.Reader) (WriteResult, error) {
+	return store.writeStream(context.Background(), key, r)
+}
+
+/*
+WriteIfNotExists stores r under key unless an object is already stored there,
+reporting whether it wrote. On a ContentAddressed store an existing key holds
+the very same content, so the write is skipped and the stored size returned
+without reading r. Otherwise an existing key fails with ErrKeyExists. The
+check and the write happen under the key's lock, no concurrent write can slip
+in between.
+*/
+func (store *DiskStore) WriteIfNotExists(key string, r io.Reader) (int64, bool, error) {
+	if err := store.writable(); err != nil {
+		return 0, false, err
+	}
+
+	start := time.Now()
+	n, wrote, err := store.writeIfNotExists(key, r)
+
+	stored := n
+	if !wrote {
+		stored = 0
+	}
+	store.Metrics.ObserveWrite(stored, time.Since(start), err)
+	if err != nil {
+		return 0, false, err
+	}
+	if wrote {
+		store.wrote(key, n)
+	}
+
+	return n, wrote, nil
+}
+
+/* writeIfNotExists implements WriteIfNotExists. */
+func (store *DiskStore) writeIfNotExists(key string, r io.Reader) (int64, bool, error) {
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer store.locks.lock(fullPathWithRoot)()
+
+	info, err := store.stat(key)
+	if err == nil {
+		if !store.ContentAddressed {
+			return 0, false, fmt.Errorf("%w (%s)", ErrKeyExists, key)
+		}
+
+		size, err := store.contentSize(fullPathWithRoot, info)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return size, false, store.addRef(pathnameWithRoot, fullPathWithRoot, false)
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return 0, false, err
+	}
+
+	result, err := store.writeObject(context.Background(), pathnameWithRoot, fullPathWithRoot, r)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return result.Bytes, true, nil
+}
+
+/*
+Copy stores the object under srcKey again under dstKey, replacing any existing
+dstKey object. It hardlinks when both paths share a filesystem, which is
+instant and takes no extra space, and falls back to a streaming copy otherwise.
+*/
+func (store *DiskStore) Copy(srcKey, dstKey string) (int64, error) {
+	if err := store.writable(); err != nil {
+		return 0, err
+	}
+
+	_, srcPath, err := store.paths(srcKey)
+	if err != nil {
+		return 0, err
+	}
+
+	dstDir, dstPath, err := store.paths(dstKey)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	unlock := store.locks.lock(srcPath, dstPath)
+	n, err := store.copyObject(srcKey, srcPath, dstDir, dstPath)
+	unlock()
+
+	store.Metrics.ObserveWrite(n, time.Since(start), err)
+
+	if err != nil {
+		return 0, err
+	}
+	store.wrote(dstKey, n)
+
+	return n, nil
+}
+
+/* copyObject implements Copy; the caller holds the locks of both paths. */
+func (store *DiskStore) copyObject(srcKey, srcPath, dstDir, dstPath string) (int64, error) {
+	info, err := store.FS.Stat(srcPath)
+	if err != nil {
+		return 0, wrapNotFound(srcKey, err)
+	}
+
+	size, err := store.contentSize(srcPath, info)
+	if err != nil {
+		return 0, err
+	}
+
+	if srcPath == dstPath {
+		return size, nil
+	}
+
+	if err := store.FS.MkdirAll(dstDir, store.DirMode); err != nil {
+		return 0, err
+	}
+
+	// link under a temp name first so an existing dstKey is swapped atomically
+	if store.MaxBytes > 0 {
+		qw, err := store.newQuotaWriter(io.Discard, dstPath, info.Size())
+		if err != nil {
+			return 0, err
+		}
+		defer qw.release()
+
+		if err := qw.claim(info.Size()); err != nil {
+			return 0, err
+		}
+	}
+
+	if linker, ok := store.FS.(Linker); ok {
+		tmp := tempFilePath(dstDir, filepath.Base(dstPath))
+		if err := linker.Link(srcPath, tmp); err == nil {
+			old, _ := store.FS.Stat(dstPath)
+			// the streaming copy below keeps it in placeObject
+			if old != nil && store.versioned() {
+				if err := store.keepVersion(dstDir, dstPath); err != nil {
+					store.FS.Remove(tmp)
+					return 0, err
+				}
+			}
+			if err := store.FS.Rename(tmp, dstPath); err != nil {
+				store.FS.Remove(tmp)
+				return 0, err
+			}
+			store.counters.replaced(old, info.Size())
+			store.bloom.add(dstPath)
+			store.checksums.forget(dstPath)
+			if err := store.copyMeta(srcPath, dstDir, dstPath); err != nil {
+				return 0, err
+			}
+			if err := store.addRef(dstDir, dstPath, old == nil); err != nil {
+				return 0, err
+			}
+			return size, store.syncDir(dstDir)
+		}
+	}
+
+	// opened by the first read of the write, inside its slot
+	file := &lazyObject{store: store, key: srcKey, path: srcPath}
+	defer file.Close()
+
+	result, err := store.writeObject(context.Background(), dstDir, dstPath, file)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Bytes, store.copyMeta(srcPath, dstDir, dstPath)
+}
+
+/*
+Move relocates the object under srcKey to dstKey. Same-filesystem moves are a
+single atomic rename; across devices it falls back to copy and delete. The
+directories left empty at the source are pruned.
+*/
+func (store *DiskStore) Move(srcKey, dstKey string) error {
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	var size int64
+	err := store.moveKey(srcKey, dstKey)
+	if err == nil {
+		size, _ = store.Size(dstKey)
+	}
+	store.Metrics.ObserveWrite(size, time.Since(start), err)
+	if err != nil {
+		return err
+	}
+	if srcKey == dstKey {
+		return nil
+	}
+
+	store.deleted(srcKey)
+	store.wrote(dstKey, size)
+
+	return nil
+}
+
+/* moveKey implements Move. */
+func (store *DiskStore) moveKey(srcKey, dstKey string) error {
+	srcDir, srcPath, err := store.paths(srcKey)
+	if err != nil {
+		return err
+	}
+
+	dstDir, dstPath, err := store.paths(dstKey)
+	if err != nil {
+		return err
+	}
+
+	defer store.locks.lock(srcPath, dstPath)()
+
+	srcInfo, err := store.FS.Stat(srcPath)
+	if err != nil {
+		return wrapNotFound(srcKey, err)
+	}
+
+	if srcPath == dstPath {
+		return nil
+	}
+
+	if err := store.FS.MkdirAll(dstDir, store.DirMode); err != nil {
+		return err
+	}
+
+	old, _ := store.FS.Stat(dstPath)
+	if old != nil && store.versioned() {
+		if err := store.keepVersion(dstDir, dstPath); err != nil {
+			return err
+		}
+	}
+
+	err = store.FS.Rename(srcPath, dstPath)
+	if errors.Is(err, syscall.EXDEV) {
+		if old != nil && store.versioned() {
+			// the revision is kept already, the copy mustn't keep the old content again
+			if err := store.FS.Remove(dstPath); err != nil {
+				return err
+			}
+			store.counters.removed(old)
+			store.checksums.forget(dstPath)
+		}
+		if _, err := store.copyObject(srcKey, srcPath, dstDir, dstPath); err != nil {
+			return err
+		}
+		if err := store.FS.Remove(srcPath); err != nil {
+			return err
+		}
+		store.counters.removed(srcInfo)
+		store.checksums.forget(srcPath)
+	} else if err != nil {
+		return err
+	} else {
+		if old != nil {
+			store.counters.removed(old)
+		}
+		store.bloom.add(dstPath)
+		store.checksums.rename(srcPath, dstPath)
+		if err := store.copyMeta(srcPath, dstDir, dstPath); err != nil {
+			return err
+		}
+	}
+
+	if err := store.removeMeta(srcPath); err != nil {
+		return err
+	}
+	if err := store.moveRefs(srcPath, dstDir, dstPath); err != nil {
+		return err
+	}
+
+	store.pruneEmptyDirs(srcDir)
+
+	return store.syncDir(dstDir)
+}
+
+/*
+Read returns a stream over the content stored under key.
+The caller must close it to release the underlying file. For plain objects,
+io.Copy from it into a TCP connection uses sendfile.
+*/
+func (store *DiskStore) Read(key string) (io.ReadCloser, error) {
+	return store.readStream(key)
+}
+
+/*
+Open returns the content stored under key as a seekable stream along with its
+file info, e.g. for http.ServeContent. Plain objects are handed out as the file
+itself. Compressed or encrypted ones are decoded on the fly, and seeking
+backwards restarts the decoding from the beginning. The info's Size is the
+content length in both cases.
+*/
+func (store *DiskStore) Open(key string) (io.ReadSeekCloser, os.FileInfo, error) {
+	_, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start := time.Now()
+	file, err := store.openRetry(fullPathWithRoot)
+	if err != nil {
+		err = wrapNotFound(key, err)
+		store.Metrics.ObserveRead(0, time.Since(start), err)
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		store.Metrics.ObserveRead(0, time.Since(start), err)
+		return nil, nil, err
+	}
+	if store.expired(info) {
+		file.Close()
+		err := expiredError(key)
+		store.expire(key, err)
+		store.Metrics.ObserveRead(0, time.Since(start), err)
+		return nil, nil, err
+	}
+
+	if !store.encoded() {
+		return store.observeOpen(start, file), info, nil
+	}
+
+	_, size, ok, err := readEnvelopeHeader(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		store.Metrics.ObserveRead(0, time.Since(start), err)
+		return nil, nil, err
+	}
+	if !ok {
+		return store.observeOpen(start, file), info, nil
+	}
+
+	return store.observeOpen(start, &decodeSeeker{store: store, file: file, size: size}), objectInfo{FileInfo: info, size: size}, nil
+}
+
+/*
+observeOpen hands out the stream Open opened at start so that Metrics observes
+it once closed. Without Metrics the stream is handed out as it is, keeping the
+sendfile fast path of http.ServeContent over a plain object's file.
+*/
+func (store *DiskStore) observeOpen(start time.Time, r io.ReadSeekCloser) io.ReadSeekCloser {
+	if _, ok := store.Metrics.(nopMetrics); ok {
+		return r
+	}
+	return &metricsSeeker{metricsReader: metricsReader{ReadCloser: r, metrics: store.Metrics, start: start}, seeker: r}
+}
+
+/* objectInfo reports the content length of an encoded object as its Size. */
+type objectInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (info objectInfo) Size() int64 { return info.size }
+
+/* ReadContext is like Read but the returned stream fails with ctx.Err() once ctx is done. */
+func (store *DiskStore) ReadContext(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := store.readStream(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contextReader{ctx: ctx, ReadCloser: file}, nil
+}
+
+/* contextReader checks its context before every Read. */
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+/*
+ReadTee is like Read but everything read from the returned stream is also
+written to w, e.g. a hash.Hash checking the content on its way to a client.
+A failing write to w fails the Read. Closing the stream closes the object but
+leaves w alone.
+*/
+func (store *DiskStore) ReadTee(key string, w io.Writer) (io.ReadCloser, error) {
+	file, err := store.readStream(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &teeReader{Reader: io.TeeReader(file, w), Closer: file}, nil
+}
+
+/*
+ReadDecompressed is like Read but decompresses content that is itself gzip
+data, as told by its magic number, e.g. payloads that were stored
+already gzipped. Other content is returned as is. This is independent of
+Compression, which reads always undo. Closing the stream closes both the
+gzip reader and the object.
+*/
+func (store *DiskStore) ReadDecompressed(key string) (io.ReadCloser, error) {
+	file, err := store.readStream(key)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(file)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return &multiCloseReader{Reader: br, closers: []io.Closer{file}}, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &multiCloseReader{Reader: gz, closers: []io.Closer{file, gz}}, nil
+}
+
+/* gzipMagic starts every gzip stream. */
+var gzipMagic = []byte{0x1f, 0x8b}
+
+/* teeReader reads through an io.TeeReader and closes the stream it tees. */
+type teeReader struct {
+	io.Reader
+	io.Closer
+}
+
+/* WriteBytes stores b under key. */
+func (store *DiskStore) WriteBytes(key string, b []byte) (int64, error) {
+	return store.Write(key, bytes.NewReader(b))
+}
+
+/* ReadBytes returns the full content stored under key. */
+func (store *DiskStore) ReadBytes(key string) ([]byte, error) {
+	file, err := store.readStream(key)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+/* WriteFile stores the content of the local file at srcPath under key. */
+func (store *DiskStore) WriteFile(key, srcPath string) (int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+
+	defer src.Close()
+
+	return store.Write(key, src)
+}
+
+/*
+ReadToFile writes the content stored under key to the local file at dstPath,
+creating or truncating it. A failed copy removes dstPath again.
+*/
+func (store *DiskStore) ReadToFile(key, dstPath string) (int64, error) {
+	file, err := store.readStream(key)
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(dst, file)
+	if err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return 0, err
+	}
+
+	return n, dst.Close()
+}
+
+func (store *DiskStore) readStream(key string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := store.openKey(key)
+	if err != nil {
+		return store.observeRead(start, nil, err)
+	}
+	if store.VerifyReads && store.ContentAddressed {
+		r = store.verifyingReader(key, r)
+	}
+
+	if store.ReadBufferSize > 0 {
+		return store.bufferedRead(r, start), nil
+	}
+
+	return store.observeRead(start, r, nil)
+}
+
+/* openKey opens and decodes the object stored under key. */
+func (store *DiskStore) openKey(key string) (io.ReadCloser, error) {
+	_, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if store.TTL > 0 {
+		if _, err := store.stat(key); err != nil {
+			store.expire(key, err)
+			return nil, err
+		}
+	}
+
+	return store.openObject(key, fullPathWithRoot)
+}
+
+/* observeRead hands r out so that Metrics observes the read started at start once r is closed. */
+func (store *DiskStore) observeRead(start time.Time, r io.ReadCloser, err error) (io.ReadCloser, error) {
+	if err != nil {
+		store.Metrics.ObserveRead(0, time.Since(start), err)
+		return nil, err
+	}
+
+	return &metricsReader{ReadCloser: r, metrics: store.Metrics, start: start}, nil
+}
+
+/* openObject opens the object file at path and decodes it. */
+func (store *DiskStore) openObject(key, path string) (io.ReadCloser, error) {
+	file, err := store.openRetry(path)
+	if err != nil {
+		return nil, wrapNotFound(key, err)
+	}
+
+	return store.decode(file)
+}
+
+/*
+lazyObject opens and decodes the object at path on its first Read, so that a
+write streaming it holds one slot for both files.
+*/
+type lazyObject struct {
+	store     *DiskStore
+	key, path string
+	r         io.ReadCloser
+}
+
+func (o *lazyObject) Read(p []byte) (int, error) {
+	if o.r == nil {
+		file, err := o.store.openHeld(o.path)
+		if err != nil {
+			return 0, wrapNotFound(o.key, err)
+		}
+		if o.r, err = o.store.decode(file); err != nil {
+			return 0, err
+		}
+	}
+	return o.r.Read(p)
+}
+
+func (o *lazyObject) Close() error {
+	if o.r == nil {
+		return nil
+	}
+	return o.r.Close()
+}
+
+func (store *DiskStore) writeStream(ctx context.Context, key string, r io.Reader) (WriteResult, error) {
+	if err := store.writable(); err != nil {
+		return WriteResult{}, err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return WriteResult{}, err
+	}
+
+	unlock := store.locks.lock(fullPathWithRoot)
+	start := time.Now()
+	result, err := store.writeObject(ctx, pathnameWithRoot, fullPathWithRoot, r)
+	unlock()
+
+	store.Metrics.ObserveWrite(result.Bytes, time.Since(start), err)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	store.wrote(key, result.Bytes)
+
+	return result, nil
+}
+
+/* wrote calls the OnWrite hook, after the key's lock has been released. */
+func (store *DiskStore) wrote(key string, size int64) {
+	if store.OnWrite != nil {
+		store.OnWrite(key, size)
+	}
+}
+
+/* deleted calls the OnDelete hook, after the key's lock has been released. */
+func (store *DiskStore) deleted(key string) {
+	if store.OnDelete != nil {
+		store.OnDelete(key)
+	}
+}
+
+/*
+writeObject stores r at fullPathWithRoot inside pathnameWithRoot. The caller
+holds the lock of fullPathWithRoot.
+*/
+func (store *DiskStore) writeObject(ctx context.Context, pathnameWithRoot, fullPathWithRoot string, r io.Reader) (WriteResult, error) {
+	if err := store.FS.MkdirAll(pathnameWithRoot, store.DirMode); err != nil {
+		return WriteResult{}, err
+	}
+
+	return store.writeObjectInDir(ctx, pathnameWithRoot, fullPathWithRoot, r)
+}
+
+/* writeObjectInDir is writeObject for callers that already created pathnameWithRoot. */
+func (store *DiskStore) writeObjectInDir(ctx context.Context, pathnameWithRoot, fullPathWithRoot string, r io.Reader) (WriteResult, error) {
+	staged, err := store.stageObject(ctx, pathnameWithRoot, fullPathWithRoot, r, store.Sync)
+	if err != nil {
+		return WriteResult{}, err
+	}
+
+	return store.placeObject(pathnameWithRoot, fullPathWithRoot, staged)
+}
+
+/* stagedObject is the finished temp file of a write, waiting to be renamed into place. */
+type stagedObject struct {
+	tmp         string
+	info        os.FileInfo
+	n           int64
+	digest      string
+	contentType string
+
+	// release gives back the MaxBytes reservation of the write
+	release func()
+}
+
+/*
+stageObject streams r into a temp file for fullPathWithRoot, syncing it when
+sync is set. The temp file is left for placeObject, or for the caller to
+remove, and the key doesn't change until then. It holds a MaxConcurrentOps
+slot while the temp file is open, taken after the caller's lock of the key;
+ctx ends the wait for it.
+*/
+func (store *DiskStore) stageObject(ctx context.Context, pathnameWithRoot, fullPathWithRoot string, r io.Reader, sync bool) (stagedObject, error) {
+	if err := store.slots.acquire(ctx); err != nil {
+		return stagedObject{}, err
+	}
+	defer store.slots.release()
+
+	// Stream into a temp file next to the destination and only rename it into
+	// place once the copy succeeded, so readers never observe a partial file.
+	tempDir := pathnameWithRoot
+	if len(store.TempDir) > 0 {
+		tempDir = store.TempDir
+	}
+
+	length := lengthOf(r)
+	if err := store.checkSpace(length, tempDir, pathnameWithRoot); err != nil {
+		return stagedObject{}, err
+	}
+
+	var contentType string
+	if store.DetectContentType {
+		var err error
+		if contentType, r, err = sniffContentType(r); err != nil {
+			return stagedObject{}, err
+		}
+	}
+
+	file, err := store.createTempFile(tempDir, filepath.Base(fullPathWithRoot))
+	if err != nil {
+		return stagedObject{}, err
+	}
+
+	if p := store.newProgress(length); p != nil {
+		r = &progressReader{r: r, p: p}
+	}
+
+	if store.MaxObjectBytes > 0 {
+		r = &maxSizeReader{r: r, remaining: store.MaxObjectBytes}
+	}
+
+	// the index hashes the same content under the same Hash, one digest serves both
+	sum := store.newDedupHash()
+	if sum == nil {
+		sum = store.newIndexHash()
+	}
+	if sum != nil {
+		r = io.TeeReader(r, sum)
+	}
+
+	var w io.Writer = file
+	release := func() {}
+	if store.MaxBytes > 0 {
+		qw, err := store.newQuotaWriter(file, fullPathWithRoot, length)
+		if err != nil {
+			file.Close()
+			store.FS.Remove(file.Name())
+			return stagedObject{}, err
+		}
+		release = qw.release
+		w = qw
+	}
+
+	fail := func(err error) (stagedObject, error) {
+		release()
+		store.FS.Remove(file.Name())
+		return stagedObject{}, err
+	}
+
+	n, err := store.encode(ctx, file, w, r)
+	if err != nil {
+		file.Close()
+		return fail(err)
+	}
+
+	if sync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return fail(err)
+		}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fail(err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fail(err)
+	}
+
+	tmp := file.Name()
+	var digest string
+	if sum != nil {
+		digest = hex.EncodeToString(sum.Sum(nil))
+	}
+	if store.deduplicating() {
+		tmp = store.dedup(tmp, digest)
+	}
+
+	return stagedObject{tmp: tmp, info: info, n: n, digest: digest, contentType: contentType, release: release}, nil
+}
+
+/*
+placeObject renames a staged temp file to fullPathWithRoot, keeping the
+version it replaces. The caller holds the lock of fullPathWithRoot.
+*/
+func (store *DiskStore) placeObject(pathnameWithRoot, fullPathWithRoot string, staged stagedObject) (WriteResult, error) {
+	defer staged.release()
+
+	tmp := staged.tmp
+	old, _ := store.FS.Stat(fullPathWithRoot)
+
+	if old != nil && store.versioned() {
+		if err := store.keepVersion(pathnameWithRoot, fullPathWithRoot); err != nil {
+			store.FS.Remove(tmp)
+			return WriteResult{}, err
+		}
+	}
+
+	err := store.renameRetry(tmp, fullPathWithRoot)
+	if errors.Is(err, syscall.EXDEV) {
+		err = store.moveAcrossDevices(tmp, pathnameWithRoot, fullPathWithRoot)
+	}
+	if err != nil {
+		store.FS.Remove(tmp)
+		return WriteResult{}, err
+	}
+
+	store.counters.replaced(old, staged.info.Size())
+	store.bloom.add(fullPathWithRoot)
+	if len(staged.digest) > 0 {
+		store.checksums.put(fullPathWithRoot, staged.digest, staged.info.Size())
+	} else {
+		store.checksums.forget(fullPathWithRoot)
+	}
+
+	if err := store.addRef(pathnameWithRoot, fullPathWithRoot, old == nil); err != nil {
+		return WriteResult{}, err
+	}
+
+	if len(staged.contentType) > 0 {
+		if err := store.setContentType(pathnameWithRoot, fullPathWithRoot, staged.contentType); err != nil {
+			return WriteResult{}, err
+		}
+	}
+
+	if err := store.syncDir(pathnameWithRoot); err != nil {
+		return WriteResult{}, err
+	}
+
+	return WriteResult{Bytes: staged.n, Created: old == nil, Overwritten: old != nil, ContentType: staged.contentType}, nil
+}
+
+/*
+moveAcrossDevices moves the finished temp file tmp to dst in dir when they are
+on different devices. It copies tmp into a temp file in dir first so that dst
+is still replaced by an atomic rename.
+*/
+func (store *DiskStore) moveAcrossDevices(tmp, dir, dst string) error {
+	src, err := store.FS.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := store.writeFile(dir, dst, src); err != nil {
+		return err
+	}
+
+	store.FS.Remove(tmp)
+
+	return nil
+}
+
+/*
+writeFile atomically replaces the file at path in dir with the content of r,
+as is, through a temp file in dir.
+*/
+func (store *DiskStore) writeFile(dir, path string, r io.Reader) error {
+	file, err := store.createTempFile(dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		store.FS.Remove(file.Name())
+		return err
+	}
+
+	if store.Sync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			store.FS.Remove(file.Name())
+			return err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		store.FS.Remove(file.Name())
+		return err
+	}
+
+	if err := store.renameRetry(file.Name(), path); err != nil {
+		store.FS.Remove(file.Name())
+		return err
+	}
+
+	return store.syncDir(dir)
+}
+
+/* syncDir fsyncs dir when Sync is set, making the entries renamed into it durable. */
+func (store *DiskStore) syncDir(dir string) error {
+	if !store.Sync {
+		return nil
+	}
+
+	return store.fsyncDir(dir)
+}
+
+/* fsyncDir fsyncs dir whatever Sync says. */
+func (store *DiskStore) fsyncDir(dir string) error {
+	d, err := store.FS.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+/* writable fails with ErrReadOnly when the store must not be modified. */
+func (store *DiskStore) writable() error {
+	if store.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+/* isObjectFile reports whether name is an object rather than a temp file or one kept alongside an object. */
+func isObjectFile(name string) bool {
+	return !isTempFile(name) && !isMetaFile(name) && !isRefsFile(name) && !isVersionFile(name) && !isUploadFile(name)
+}
+
+/* isTempFile reports whether name belongs to a write that has not been renamed into place. */
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempFileSuffix)
+}
+
+/*
+createTempFile creates a new hidden file in dir named after filename with a
+random suffix, e.g. ".<filename>.<random>.tmp".
+*/
+func (store *DiskStore) createTempFile(dir, filename string) (File, error) {
+	for i := 0; i < 10000; i++ {
+		name := tempFilePath(dir, filename)
+
+		var file File
+		err := store.retry(func() (err error) {
+			file, err = store.FS.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, store.FileMode)
+			return err
+		})
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+
+		return file, err
+	}
+
+	return nil, fmt.Errorf("could not create temp file for (%s) in %s", filename, dir)
+}
+
+/* tempFilePath returns a fresh random temp file path for filename in dir. */
+func tempFilePath(dir, filename string) string {
+	return filepath.Join(dir, fmt.Sprintf(".%s.%s%s", filename, strconv.FormatUint(uint64(rand.Uint32()), 36), tempFileSuffix))
+}
+
+/*
+paths resolves key into the directory holding its object and the object's full
+path, both under Root. Keys whose transformed path would escape Root, lie in a
+reserved directory or end in the name of a file kept alongside objects are
+rejected with ErrInvalidKey.
+*/
+func (store *DiskStore) paths(key string) (pathnameWithRoot string, fullPathWithRoot string, err error) {
+	pathKey := store.PathTransformFunc(key)
+
+	pathnameWithRoot = filepath.Join(store.Root, pathKey.Pathname)
+	fullPathWithRoot = filepath.Join(store.Root, pathKey.FullPath())
+
+	if pathnameWithRoot != store.Root && !store.insideRoot(pathnameWithRoot) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	if !store.insideRoot(fullPathWithRoot) || fullPathWithRoot == pathnameWithRoot {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	if store.inReservedDir(fullPathWithRoot) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	// GC and the listings would take such an object for a file kept alongside another
+	if !isObjectFile(filepath.Base(fullPathWithRoot)) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	// a Filename with slashes, as keys like "docs/a.txt" have, nests the object deeper
+	return filepath.Dir(fullPathWithRoot), fullPathWithRoot, nil
+}
+
+/* reservedDir reports whether path is one of the directories the store keeps below Root for itself. */
+func (store *DiskStore) reservedDir(path string) bool {
+	return path == store.trashRoot() || path == store.locksRoot() || path == store.dedupRoot() || path == store.journalRoot() || path == store.checksumsRoot()
+}
+
+/* inReservedDir reports whether path is or lies below one of the reserved directories. */
+func (store *DiskStore) inReservedDir(path string) bool {
+	for _, reserved := range []string{store.trashRoot(), store.locksRoot(), store.dedupRoot(), store.journalRoot(), store.checksumsRoot()} {
+		if path == reserved || strings.HasPrefix(path, reserved+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+/* insideRoot reports whether the cleaned path lies strictly below Root. */
+func (store *DiskStore) insideRoot(path string) bool {
+	rel, err := filepath.Rel(store.Root, path)
+	if err != nil || rel == "." {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+/* maxSizeReader fails with ErrObjectTooLarge once r yields more than remaining bytes. */
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	// read one byte past the limit to tell "exactly at the cap" from "over it"
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return 0, ErrObjectTooLarge
+	}
+
+	return n, err
+}
+
+/*
+copyContext copies from src to dst like io.Copy, checking ctx between chunks
+so that a cancelled context stops the copy early.
+*/
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	// contexts that can never be cancelled take the io.Copy fast path
+	if ctx.Done() == nil {
+		return io.Copy(dst, src)
+	}
+
+	var (
+		buf     = make([]byte, 32*1024)
+		written int64
+	)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
 }
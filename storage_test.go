@@ -2,9 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestPathTransformFunc(t *testing.T) {
@@ -20,20 +36,76 @@ func TestPathTransformFunc(t *testing.T) {
 	}
 }
 
+func TestNewCASPathTransformFunc(t *testing.T) {
+	transform := NewCASPathTransformFunc(sha256.New, 8)
+	pathKey := transform("onepiecepicture")
+
+	if len(pathKey.Filename) != sha256.Size*2 {
+		t.Errorf("expected a %d char filename, have %s", sha256.Size*2, pathKey.Filename)
+	}
+
+	blocks := strings.Split(pathKey.Pathname, "/")
+	if len(blocks) != 8 {
+		t.Errorf("expected 8 path blocks, have %d", len(blocks))
+	}
+	if strings.Join(blocks, "") != pathKey.Filename {
+		t.Errorf("expected path blocks to spell out %s, have %s", pathKey.Filename, pathKey.Pathname)
+	}
+}
+
+func TestCASPathTransformFuncKeepsTrailingBlock(t *testing.T) {
+	// 40 hex chars don't divide into blocks of 6, leaving a 4 char remainder
+	transform := NewCASPathTransformFunc(sha1.New, 6)
+	pathKey := transform("onepiecepicture")
+
+	expectedPathname := "eac313/584ec0/f3e5a5/da458a/b909f4/0bc763/df5c"
+	if pathKey.Pathname != expectedPathname {
+		t.Errorf("have %s, expected %s", pathKey.Pathname, expectedPathname)
+	}
+	if strings.ReplaceAll(pathKey.Pathname, "/", "") != pathKey.Filename {
+		t.Errorf("expected pathname %s to cover the full filename %s", pathKey.Pathname, pathKey.Filename)
+	}
+}
+
+func TestCASPathTransformFuncWithOptions(t *testing.T) {
+	transform := NewCASPathTransformFuncWithOptions(sha256.New, CASOptions{BlockSize: 2, Depth: 2})
+	pathKey := transform("onepiecepicture")
+
+	if len(pathKey.Filename) != sha256.Size*2 {
+		t.Errorf("expected a %d char filename, have %s", sha256.Size*2, pathKey.Filename)
+	}
+	if expected := pathKey.Filename[:2] + "/" + pathKey.Filename[2:4]; pathKey.Pathname != expected {
+		t.Errorf("have %s, expected %s", pathKey.Pathname, expected)
+	}
+
+	transform = NewCASPathTransformFuncWithOptions(sha256.New, CASOptions{BlockSize: 4, Depth: 1, Encoding: CASBase32})
+	pathKey = transform("onepiecepicture")
+
+	if len(pathKey.Filename) != 52 {
+		t.Errorf("expected a 52 char base32 filename, have %s", pathKey.Filename)
+	}
+	if pathKey.Filename != strings.ToLower(pathKey.Filename) {
+		t.Errorf("expected a lowercase filename, have %s", pathKey.Filename)
+	}
+	if pathKey.Pathname != pathKey.Filename[:4] {
+		t.Errorf("have %s, expected %s", pathKey.Pathname, pathKey.Filename[:4])
+	}
+}
+
 func TestStorage(t *testing.T) {
-	s := newStorage()
+	s := newStorage(t)
 	defer teardown(t, s)
 
 	for i := 0; i < 50; i++ {
 		key := fmt.Sprintf("foo_%d", i)
 		data := []byte("some jpg bytes")
 
-		if err := s.writeStream(key, bytes.NewReader(data)); err != nil {
+		if _, err := s.Write(key, bytes.NewReader(data)); err != nil {
 			t.Error(err)
 		}
 
-		if ok := s.Has(key); !ok {
-			t.Errorf("expected to have have %s", key)
+		if ok, err := s.Has(key); err != nil || !ok {
+			t.Errorf("expected to have have %s (%v)", key, err)
 		}
 
 		r, err := s.Read(key)
@@ -42,6 +114,7 @@ func TestStorage(t *testing.T) {
 		}
 
 		b, _ := io.ReadAll(r)
+		r.Close()
 		if string(b) != string(data) {
 			t.Errorf("expected %s have %s", data, b)
 		}
@@ -50,21 +123,1246 @@ func TestStorage(t *testing.T) {
 			t.Error(err)
 		}
 
-		if ok := s.Has(key); ok {
-			t.Errorf("expected to NOT have key %s", key)
+		if ok, err := s.Has(key); err != nil || ok {
+			t.Errorf("expected to NOT have key %s (%v)", key, err)
+		}
+	}
+}
+
+func TestStorageHasSurfacesStatErrors(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	// a regular file where a directory should be makes stat fail with ENOTDIR
+	if _, err := s.Write("blocker", bytes.NewReader([]byte("x"))); err != nil {
+		t.Fatal(err)
+	}
+	pathKey := s.PathTransformFunc("blocker")
+	s.PathTransformFunc = func(key string) PathKey {
+		return PathKey{Pathname: pathKey.FullPath(), Filename: key}
+	}
+
+	ok, err := s.Has("nested")
+	if ok {
+		t.Error("expected Has to report false")
+	}
+	if err == nil {
+		t.Error("expected the stat error to be returned")
+	}
+}
+
+func TestStorageSize(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	if _, err := s.Write("sized", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := s.Size("sized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expected size %d, have %d", len(data), size)
+	}
+
+	if _, err := s.Size("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageStat(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{Compression: CompressionGzip})
+	defer teardown(t, s)
+
+	content := bytes.Repeat([]byte("compressible "), 100)
+	if _, err := s.Write("stat", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.Stat("stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(content)) {
+		t.Errorf("expected the content length %d, have %d", len(content), info.Size())
+	}
+	if !info.Mode().IsRegular() || info.ModTime().IsZero() {
+		t.Errorf("unexpected info %v %v", info.Mode(), info.ModTime())
+	}
+
+	if _, err := s.Stat("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageTouch(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("touched", bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+	_, fullPath, _ := s.paths("touched")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(fullPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Touch("touched"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.Stat("touched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(info.ModTime()) > time.Minute {
+		t.Errorf("expected a fresh modification time, have %v", info.ModTime())
+	}
+
+	if err := s.Touch("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageKeys(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	want := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		if _, err := s.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+		want[s.PathTransformFunc(key).Filename] = true
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(want) {
+		t.Errorf("expected %d keys, have %d", len(want), len(keys))
+	}
+	for _, k := range keys {
+		if !want[k] {
+			t.Errorf("unexpected key %s", k)
+		}
+	}
+}
+
+func TestStorageKeysWithPrefix(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{Root: "prefixroot"})
+	defer teardown(t, s)
+
+	for _, key := range []string{"docs/report.txt", "docs/readme.md", "docs/old/notes.txt", "images/logo.png"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prefixes := map[string][]string{
+		"docs/":   {"docs/old/notes.txt", "docs/readme.md", "docs/report.txt"},
+		"docs/re": {"docs/readme.md", "docs/report.txt"},
+		"images":  {"images/logo.png"},
+		"videos/": {},
+	}
+	for prefix, want := range prefixes {
+		keys, err := s.KeysWithPrefix(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(keys) != fmt.Sprint(want) {
+			t.Errorf("expected %v for prefix %q, have %v", want, prefix, keys)
+		}
+	}
+}
+
+func TestStorageClear(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	if _, err := s.Write("before", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		t.Fatalf("expected root to survive Clear: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected an empty root, have %d entries", len(entries))
+	}
+
+	if _, err := s.Write("after", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Has("after"); err != nil || !ok {
+		t.Errorf("expected to have after (%v)", err)
+	}
+}
+
+// stuckFS fails to remove the tree of the top-level directory stuck.
+type stuckFS struct {
+	FS
+	stuck string
+}
+
+func (f *stuckFS) RemoveAll(path string) error {
+	if filepath.Base(path) == f.stuck {
+		return errInjected
+	}
+	return f.FS.RemoveAll(path)
+}
+
+func TestStorageParallelClear(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		ParallelClear: true,
+		BatchWorkers:  4,
+		FS:            &stuckFS{FS: OSFS{}, stuck: "key07"},
+	})
+
+	for i := 0; i < 20; i++ {
+		if _, err := s.Write(fmt.Sprintf("key%02d", i), strings.NewReader("data")); err != nil {
+			t.Fatal(err)
 		}
 	}
+
+	if err := s.Clear(); !errors.Is(err, errInjected) {
+		t.Errorf("expected %v, have %v", errInjected, err)
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "key07" {
+		t.Errorf("expected only the stuck key to survive, have %v", keys)
+	}
+
+	s.FS = OSFS{}
+	teardown(t, s)
 }
 
-func newStorage() *Storage {
+func TestStoragePermissions(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		DirMode:           0700,
+		FileMode:          0600,
+	})
+	defer teardown(t, s)
+
+	key := "private"
+	if _, err := s.Write(key, bytes.NewReader([]byte("secret"))); err != nil {
+		t.Fatal(err)
+	}
+
+	pathKey := s.PathTransformFunc(key)
+	fileInfo, err := os.Stat(filepath.Join(s.Root, pathKey.FullPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fileInfo.Mode().Perm(); mode != 0600 {
+		t.Errorf("expected file mode 0600, have %o", mode)
+	}
+
+	dirInfo, err := os.Stat(filepath.Join(s.Root, pathKey.Pathname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := dirInfo.Mode().Perm(); mode != 0700 {
+		t.Errorf("expected dir mode 0700, have %o", mode)
+	}
+}
+
+func TestStorageRejectsPathTraversal(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{Root: "traversalroot"})
+	defer teardown(t, s)
+
+	for _, key := range []string{"../escaped", "../../etc/passwd", "a/../../escaped", ""} {
+		if _, err := s.Write(key, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("write %q: expected %v, have %v", key, ErrInvalidKey, err)
+		}
+		if _, err := s.Read(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("read %q: expected %v, have %v", key, ErrInvalidKey, err)
+		}
+		if err := s.Delete(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("delete %q: expected %v, have %v", key, ErrInvalidKey, err)
+		}
+	}
+
+	if _, err := os.Stat("escaped"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected nothing to be written outside of the root")
+	}
+}
+
+func TestNewStorageCreatesRoot(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{Root: "freshroot"})
+	defer teardown(t, s)
+
+	info, err := os.Stat(s.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("expected %s to be a directory", s.Root)
+	}
+
+	keys, err := s.Keys()
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected an empty fresh store, have %v (%v)", keys, err)
+	}
+}
+
+func TestStorageRootNormalization(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	want := newStorageWithOptions(t, StorageOptions{Root: "data"})
+	_, wantPath, err := want.paths("picture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(want.Root) {
+		t.Errorf("expected an absolute root, have %s", want.Root)
+	}
+
+	for _, root := range []string{"data/", "./data", "data//", filepath.Join(dir, "data") + "/"} {
+		s := newStorageWithOptions(t, StorageOptions{Root: root})
+		if s.Root != want.Root {
+			t.Errorf("expected root %q to become %s, have %s", root, want.Root, s.Root)
+		}
+
+		_, fullPath, err := s.paths("picture")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fullPath != wantPath {
+			t.Errorf("expected root %q to store at %s, have %s", root, wantPath, fullPath)
+		}
+	}
+}
+
+func TestStorageLogger(t *testing.T) {
+	logs := new(bytes.Buffer)
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Logger:            slog.New(slog.NewJSONHandler(logs, nil)),
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("logged", bytes.NewReader([]byte("x"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("logged"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(logs.String(), `"key":"logged"`) {
+		t.Errorf("expected a structured delete log, have %s", logs)
+	}
+}
+
+func TestStorageStrictDelete(t *testing.T) {
+	lenient := newStorage(t)
+	defer teardown(t, lenient)
+
+	if err := lenient.Delete("missing_key"); err != nil {
+		t.Errorf("expected lenient delete of a missing key to succeed, have %v", err)
+	}
+
+	strict := newStorageWithOptions(t, StorageOptions{
+		Root:              "strictroot",
+		PathTransformFunc: CASPathTransformFunc,
+		StrictDelete:      true,
+	})
+	defer teardown(t, strict)
+
+	if err := strict.Delete("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageDeleteKeepsSiblingKeys(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	// both keys share the first path block
+	s.PathTransformFunc = func(key string) PathKey {
+		return PathKey{Pathname: "abcde/" + key, Filename: key}
+	}
+
+	data := []byte("some jpg bytes")
+	for _, key := range []string{"first", "second"} {
+		if _, err := s.Write(key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Delete("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := s.Has("first"); ok {
+		t.Error("expected to NOT have key first")
+	}
+	if ok, err := s.Has("second"); err != nil || !ok {
+		t.Errorf("expected sibling key second to survive (%v)", err)
+	}
+}
+
+func TestStorageDeleteDefaultTransform(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	// "a" is stored at a/a and "a/b" at a/b/a/b, inside the same top directory
+	keys := []string{"a", "a/b", "foo/bar", "foo/baz"}
+	for _, key := range keys {
+		if _, err := s.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{"a", "foo/bar"} {
+		if err := s.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+
+		_, fullPath, _ := s.paths(key)
+		if _, err := os.Stat(fullPath); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the file of %s to be removed, have %v", key, err)
+		}
+	}
+
+	for _, key := range []string{"a/b", "foo/baz"} {
+		b, err := s.ReadBytes(key)
+		if err != nil {
+			t.Fatalf("expected %s to survive: %v", key, err)
+		}
+		if string(b) != key {
+			t.Errorf("unexpected content of %s: %q", key, b)
+		}
+	}
+}
+
+func TestStoragePruneEmptyDirs(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		PruneEmptyDirs:    true,
+	})
+	defer teardown(t, s)
+
+	s.PathTransformFunc = func(key string) PathKey {
+		return PathKey{Pathname: "shared/" + key, Filename: key}
+	}
+
+	data := []byte("some jpg bytes")
+	for _, key := range []string{"first", "second"} {
+		if _, err := s.Write(key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Delete("first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Root, "shared", "first")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected the empty directory of first to be pruned")
+	}
+	if _, err := os.Stat(filepath.Join(s.Root, "shared")); err != nil {
+		t.Error("expected the shared directory to stay while second lives in it")
+	}
+
+	if err := s.Delete("second"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		t.Fatalf("expected root to survive pruning: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected an empty root, have %d entries", len(entries))
+	}
+}
+
+func TestStorageReadOnly(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("existing", bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+	ro := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		ReadOnly:          true,
+	})
+
+	if _, err := ro.Write("new", bytes.NewReader([]byte("data"))); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected Write to fail with %v, have %v", ErrReadOnly, err)
+	}
+	if err := ro.Delete("existing"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected Delete to fail with %v, have %v", ErrReadOnly, err)
+	}
+	if err := ro.Clear(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected Clear to fail with %v, have %v", ErrReadOnly, err)
+	}
+
+	if b, err := ro.ReadBytes("existing"); err != nil || string(b) != "data" {
+		t.Errorf("expected reads to work, have %q (%v)", b, err)
+	}
+	if keys, err := ro.Keys(); err != nil || len(keys) != 1 {
+		t.Errorf("expected 1 key, have %v (%v)", keys, err)
+	}
+}
+
+func TestStorageHooks(t *testing.T) {
+	var events []string
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		OnWrite: func(key string, size int64) {
+			events = append(events, fmt.Sprintf("write %s %d", key, size))
+		},
+		OnDelete: func(key string) {
+			events = append(events, "delete "+key)
+		},
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("a", bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed operations don't fire
+	s.StrictDelete = true
+	if err := s.Delete("b"); err == nil {
+		t.Fatal("expected deleting a missing key to fail")
+	}
+	if _, err := s.Write("c", &failingReader{r: bytes.NewReader([]byte("data"))}); err == nil {
+		t.Fatal("expected the failing write to fail")
+	}
+
+	want := []string{"write a 4", "delete a", "write b 4", "delete b"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("expected events %v, have %v", want, events)
+	}
+}
+
+func TestStorageWriteReport(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	result, err := s.WriteReport("report", bytes.NewReader([]byte("first")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (WriteResult{Bytes: 5, Created: true}) {
+		t.Errorf("unexpected first write result %+v", result)
+	}
+
+	result, err = s.WriteReport("report", bytes.NewReader([]byte("second")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (WriteResult{Bytes: 6, Overwritten: true}) {
+		t.Errorf("unexpected overwrite result %+v", result)
+	}
+}
+
+func TestStorageWriteIfNotExists(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	n, wrote, err := s.WriteIfNotExists("report", bytes.NewReader([]byte("first")))
+	if err != nil || !wrote || n != 5 {
+		t.Fatalf("expected (5, true, nil), have (%d, %v, %v)", n, wrote, err)
+	}
+
+	_, wrote, err = s.WriteIfNotExists("report", bytes.NewReader([]byte("second")))
+	if wrote || !errors.Is(err, ErrKeyExists) {
+		t.Errorf("expected (false, %v), have (%v, %v)", ErrKeyExists, wrote, err)
+	}
+	if b, _ := s.ReadBytes("report"); string(b) != "first" {
+		t.Errorf("expected the existing content to stay, have %q", b)
+	}
+
+	cas := newContentAddressedStorage(t)
+	defer teardown(t, cas)
+
+	data := []byte("deduplicated content")
+	key := writeContentAddressed(t, cas, data)
+
+	// a reader failing on first use proves the content is not copied again
+	n, wrote, err = cas.WriteIfNotExists(key, &failingReader{r: bytes.NewReader(data)})
+	if err != nil || wrote || n != int64(len(data)) {
+		t.Errorf("expected (%d, false, nil), have (%d, %v, %v)", len(data), n, wrote, err)
+	}
+}
+
+func TestStorageCopy(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	if _, err := s.Write("original", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Copy("original", "alias")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("expected %d bytes copied, have %d", len(data), n)
+	}
+
+	// overwriting the alias must not touch the original it was linked from
+	if _, err := s.Write("alias", bytes.NewReader([]byte("new content"))); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Read("original")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != string(data) {
+		t.Errorf("expected %s have %s", data, b)
+	}
+
+	if _, err := s.Copy("missing_key", "other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageMove(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	if _, err := s.Write("source", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Move("source", "destination"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := s.Has("source"); ok {
+		t.Error("expected to NOT have key source")
+	}
+	r, err := s.Read("destination")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != string(data) {
+		t.Errorf("expected %s have %s", data, b)
+	}
+
+	srcDir := filepath.Join(s.Root, s.PathTransformFunc("source").FirstPathname())
+	if _, err := os.Stat(srcDir); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected the empty source directories to be pruned")
+	}
+}
+
+func TestStorageWriteWithHash(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	n, digest, err := s.WriteWithHash("hashed", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("expected %d bytes written, have %d", len(data), n)
+	}
+
+	sum := sha256.Sum256(data)
+	if expected := hex.EncodeToString(sum[:]); digest != expected {
+		t.Errorf("expected digest %s, have %s", expected, digest)
+	}
+}
+
+func TestStorageBytesAndFileHelpers(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	if _, err := s.WriteBytes("bytes", data); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.ReadBytes("bytes"); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("expected %s have %s (%v)", data, b, err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.jpg")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteFile("file", src); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "dst.jpg")
+	if n, err := s.ReadToFile("file", dst); err != nil || n != int64(len(data)) {
+		t.Fatalf("expected %d bytes, have %d (%v)", len(data), n, err)
+	}
+	if b, _ := os.ReadFile(dst); !bytes.Equal(b, data) {
+		t.Errorf("expected %s have %s", data, b)
+	}
+}
+
+func TestStorageConcurrentWritesSameKey(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte('a' + i)}, 1<<16)
+			if _, err := s.Write("contended", bytes.NewReader(data)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	b, err := s.ReadBytes("contended")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1<<16 || !bytes.Equal(b, bytes.Repeat(b[:1], len(b))) {
+		t.Error("expected the content of exactly one writer")
+	}
+}
+
+func TestStorageMaxObjectBytes(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxObjectBytes:    1024,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.Write("atcap", bytes.NewReader(make([]byte, 1024))); err != nil {
+		t.Errorf("expected an object at the cap to be stored, have %v", err)
+	}
+
+	key := "runaway"
+	if _, err := s.Write(key, bytes.NewReader(make([]byte, 1<<20))); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("expected %v, have %v", ErrObjectTooLarge, err)
+	}
+
+	pathKey := s.PathTransformFunc(key)
+	entries, err := os.ReadDir(filepath.Join(s.Root, pathKey.Pathname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no leftover files, have %d entries", len(entries))
+	}
+}
+
+func TestStorageOpen(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+
+	for _, opts := range []StorageOptions{
+		{PathTransformFunc: CASPathTransformFunc},
+		{
+			PathTransformFunc: CASPathTransformFunc,
+			Compression:       CompressionGzip,
+			EncryptionKey:     bytes.Repeat([]byte{7}, encryptionKeySize),
+		},
+	} {
+		s := newStorageWithOptions(t, opts)
+
+		if _, err := s.Write("video", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		file, info, err := s.Open("video")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Errorf("expected size %d, have %d", len(data), info.Size())
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/video", nil)
+		req.Header.Set("Range", "bytes=99990-99994")
+		rec := httptest.NewRecorder()
+		http.ServeContent(rec, req, "video", info.ModTime(), file)
+
+		if rec.Code != http.StatusPartialContent {
+			t.Errorf("expected status %d, have %d", http.StatusPartialContent, rec.Code)
+		}
+		if have := rec.Body.String(); have != "01234" {
+			t.Errorf("expected range content %q, have %q", "01234", have)
+		}
+
+		// seeking backwards has to work for encoded objects as well
+		if _, err := file.Seek(5, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(file, b); err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "567" {
+			t.Errorf("expected %q after seeking, have %q", "567", b)
+		}
+
+		if err := file.Close(); err != nil {
+			t.Error(err)
+		}
+
+		if _, _, err := s.Open("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+		}
+
+		teardown(t, s)
+	}
+}
+
+func TestStorageReadMissingKey(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Read("missing_key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageReadContextCancelled(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("cancelledread", bytes.NewReader([]byte("some jpg bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := s.ReadContext(ctx, "cancelledread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cancel()
+	if _, err := io.ReadAll(r); err != context.Canceled {
+		t.Errorf("expected %v, have %v", context.Canceled, err)
+	}
+}
+
+func TestStorageReadTee(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	content := []byte("some jpg bytes")
+	if _, err := s.Write("teed", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := sha256.New()
+	r, err := s.ReadTee("teed", hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, content) {
+		t.Errorf("unexpected content %q", b)
+	}
+	if sum := sha256.Sum256(content); !bytes.Equal(hasher.Sum(nil), sum[:]) {
+		t.Error("expected the teed digest to match the content")
+	}
+
+	if _, err := s.ReadTee("missing_key", hasher); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageWriteContextCancelled(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	key := "cancelledwrite"
+	if _, err := s.WriteContext(ctx, key, bytes.NewReader([]byte("some jpg bytes"))); err != context.Canceled {
+		t.Errorf("expected %v, have %v", context.Canceled, err)
+	}
+
+	if ok, _ := s.Has(key); ok {
+		t.Errorf("expected partial file for %s to be removed", key)
+	}
+}
+
+func TestStorageReadDoesNotLeakDescriptors(t *testing.T) {
+	if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("descriptor counting needs /proc/self/fd")
+	}
+
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	key := "leakcheck"
+	if _, err := s.Write(key, bytes.NewReader([]byte("some jpg bytes"))); err != nil {
+		t.Fatal(err)
+	}
+
+	before := openDescriptors(t)
+	for i := 0; i < 100; i++ {
+		r, err := s.Read(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+
+		if _, err := s.Read("missing_key"); err == nil {
+			t.Error("expected an error reading a missing key")
+		}
+	}
+
+	if after := openDescriptors(t); after > before {
+		t.Errorf("leaked %d file descriptors", after-before)
+	}
+}
+
+func openDescriptors(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestStorageWriteIsAtomic(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	key := "atomicwrite"
+	data := []byte("the original bytes")
+	if _, err := s.Write(key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &failingReader{r: bytes.NewReader([]byte("replacement bytes")), n: 5}
+	if _, err := s.Write(key, r); !errors.Is(err, errFailingReader) {
+		t.Errorf("expected %v, have %v", errFailingReader, err)
+	}
+
+	rd, err := s.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	if b, _ := io.ReadAll(rd); string(b) != string(data) {
+		t.Errorf("expected %s have %s", data, b)
+	}
+
+	pathKey := s.PathTransformFunc(key)
+	entries, err := os.ReadDir(filepath.Join(s.Root, pathKey.Pathname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the stored file, have %d entries", len(entries))
+	}
+}
+
+func TestStorageWriteFailureLeavesNoPartialFile(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	key := "partialwrite"
+	r := &failingReader{r: bytes.NewReader(bytes.Repeat([]byte("x"), 1<<16)), n: 1 << 15}
+	if _, err := s.Write(key, r); !errors.Is(err, errFailingReader) {
+		t.Errorf("expected %v, have %v", errFailingReader, err)
+	}
+
+	if ok, _ := s.Has(key); ok {
+		t.Errorf("expected to NOT have key %s", key)
+	}
+
+	pathKey := s.PathTransformFunc(key)
+	entries, err := os.ReadDir(filepath.Join(s.Root, pathKey.Pathname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no leftover files, have %d entries", len(entries))
+	}
+}
+
+var errFailingReader = errors.New("failing reader")
+
+// failingReader yields n bytes from r and then fails.
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errFailingReader
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+func newStorage(t *testing.T) *DiskStore {
 	opts := StorageOptions{
 		PathTransformFunc: CASPathTransformFunc,
 	}
-	return NewStorage(opts)
+	return newStorageWithOptions(t, opts)
+}
+
+func newContentAddressedStorage(t *testing.T) *DiskStore {
+	opts := StorageOptions{
+		Root:              "casroot",
+		PathTransformFunc: NewDigestPathTransformFunc(5),
+		ContentAddressed:  true,
+	}
+	return newStorageWithOptions(t, opts)
+}
+
+// writeContentAddressed stores data under its SHA-256 digest and returns the key.
+func writeContentAddressed(t *testing.T, s *DiskStore, data []byte) string {
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	if _, err := s.Write(key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newStorageWithOptions(t *testing.T, opts StorageOptions) *DiskStore {
+	s, err := NewDiskStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
 }
 
-func teardown(t *testing.T, s *Storage) {
+func teardown(t *testing.T, s *DiskStore) {
 	if err := s.Clear(); err != nil {
 		t.Error(err)
 	}
+	if err := os.Remove(s.Root); err != nil {
+		t.Error(err)
+	}
+}
+
+/*
+BenchmarkReadIntoTCP copies a large object into a loopback TCP connection.
+The sendfile case copies the stream Read returns, the userspace case hides
+its WriteTo like a plain io.Reader wrapper would, forcing every byte through
+a buffer; compare their CPU time with -cpuprofile or time(1).
+*/
+func BenchmarkReadIntoTCP(b *testing.B) {
+	s, err := NewDiskStore(StorageOptions{Root: b.TempDir(), PathTransformFunc: CASPathTransformFunc})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	const size = 64 << 20
+	if _, err := s.Write("large", io.LimitReader(rand.New(rand.NewSource(1)), size)); err != nil {
+		b.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	for _, bc := range []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"sendfile", func(r io.Reader) io.Reader { return r }},
+		{"userspace", func(r io.Reader) io.Reader { return struct{ io.Reader }{r} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				r, err := s.Read("large")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(conn, bc.wrap(r)); err != nil {
+					b.Fatal(err)
+				}
+				r.Close()
+			}
+		})
+	}
+}
+
+/* fileReaderFrom records the reader io.Copy hands to ReadFrom, as a TCP connection would use it. */
+type fileReaderFrom struct {
+	src io.Reader
+}
+
+func (w *fileReaderFrom) Write(p []byte) (int, error) { return len(p), nil }
+
+func (w *fileReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	w.src = r
+	return io.Copy(io.Discard, r)
+}
+
+func TestStorageReadKeepsFileForSendfile(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.WriteBytes("large", bytes.Repeat([]byte("x"), 1<<16)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Read("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	w := &fileReaderFrom{}
+	if n, err := io.Copy(w, r); err != nil || n != 1<<16 {
+		t.Fatalf("expected to copy 65536 bytes, have %d (%v)", n, err)
+	}
+	// os.File hides its WriteTo behind a wrapper that net's sendfile still recognizes
+	if _, ok := w.src.(interface {
+		SyscallConn() (syscall.RawConn, error)
+	}); !ok {
+		t.Errorf("expected ReadFrom to receive the file, have %T", w.src)
+	}
+}
+
+func TestStorageReadBufferSize(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		ReadBufferSize:    4096,
+	})
+	defer teardown(t, s)
+
+	if _, err := s.WriteBytes("lines", []byte("first\nsecond\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Read("lines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, ok := r.(*BufferedReadCloser)
+	if !ok {
+		t.Fatalf("expected a *BufferedReadCloser, have %T", r)
+	}
+	if line, err := br.ReadString('\n'); err != nil || line != "first\n" {
+		t.Errorf("expected the first line, have %q (%v)", line, err)
+	}
+	if rest, _ := io.ReadAll(br); string(rest) != "second\n" {
+		t.Errorf("expected the rest, have %q", rest)
+	}
+	if err := br.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+BenchmarkSmallReads reads an object 64 bytes at a time, unbuffered and with a
+ReadBufferSize. Every unbuffered read is a syscall, and on NFS a round trip.
+*/
+func BenchmarkSmallReads(b *testing.B) {
+	const size = 1 << 20
+	content := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+
+	for _, bufferSize := range []int{0, 64 << 10} {
+		b.Run(fmt.Sprintf("buffer=%d", bufferSize), func(b *testing.B) {
+			s, err := NewDiskStore(StorageOptions{
+				Root:              b.TempDir(),
+				PathTransformFunc: CASPathTransformFunc,
+				ReadBufferSize:    bufferSize,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := s.WriteBytes("small-reads", content); err != nil {
+				b.Fatal(err)
+			}
+
+			p := make([]byte, 64)
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				r, err := s.Read("small-reads")
+				if err != nil {
+					b.Fatal(err)
+				}
+				for {
+					if _, err := r.Read(p); err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+				r.Close()
+			}
+		})
+	}
 }
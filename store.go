@@ -0,0 +1,19 @@
+package main
+
+import "io"
+
+/*
+Store is anything that can hold objects by key, so callers don't depend on
+where the bytes actually live. DiskStore is the local filesystem backend.
+*/
+type Store interface {
+	Has(key string) (bool, error)
+	Size(key string) (int64, error)
+	Read(key string) (io.ReadCloser, error)
+	Write(key string, r io.Reader) (int64, error)
+	Delete(key string) error
+	Keys() ([]string, error)
+	Clear() error
+}
+
+var _ Store = (*DiskStore)(nil)
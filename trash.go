@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/*
+trashDirName is the directory below Root that deleted objects are moved to
+when Trash is set, keeping their path relative to Root.
+*/
+const trashDirName = ".trash"
+
+func (store *DiskStore) trashRoot() string {
+	return filepath.Join(store.Root, trashDirName)
+}
+
+/* trashPath returns where the object at fullPathWithRoot goes when it is trashed. */
+func (store *DiskStore) trashPath(fullPathWithRoot string) (string, error) {
+	rel, err := filepath.Rel(store.Root, fullPathWithRoot)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(store.trashRoot(), rel), nil
+}
+
+/* trash moves the object at fullPathWithRoot, its sidecar and its revisions into the trash. */
+func (store *DiskStore) trash(fullPathWithRoot string) error {
+	trashed, err := store.trashPath(fullPathWithRoot)
+	if err != nil {
+		return err
+	}
+
+	if err := store.FS.MkdirAll(filepath.Dir(trashed), store.DirMode); err != nil {
+		return err
+	}
+
+	if err := store.FS.Rename(fullPathWithRoot, trashed); err != nil {
+		return err
+	}
+
+	if err := store.moveMetaFile(fullPathWithRoot, trashed); err != nil {
+		return err
+	}
+	return store.moveVersions(fullPathWithRoot, trashed)
+}
+
+/*
+Restore brings back the object under key from the trash, with its metadata
+and revisions. It fails with
+ErrKeyNotFound when key is not in the trash and with ErrKeyExists when a new
+object has been stored under key since it was deleted.
+*/
+func (store *DiskStore) Restore(key string) error {
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return err
+	}
+
+	defer store.locks.lock(fullPathWithRoot)()
+
+	trashed, err := store.trashPath(fullPathWithRoot)
+	if err != nil {
+		return err
+	}
+
+	info, err := store.FS.Stat(trashed)
+	if err != nil {
+		return wrapNotFound(key, err)
+	}
+
+	if _, err := store.FS.Stat(fullPathWithRoot); err == nil {
+		return fmt.Errorf("%w (%s)", ErrKeyExists, key)
+	}
+
+	if err := store.FS.MkdirAll(pathnameWithRoot, store.DirMode); err != nil {
+		return err
+	}
+
+	if err := store.FS.Rename(trashed, fullPathWithRoot); err != nil {
+		return err
+	}
+	store.counters.replaced(nil, info.Size())
+	store.bloom.add(fullPathWithRoot)
+	store.checksums.forget(fullPathWithRoot)
+
+	if err := store.moveMetaFile(trashed, fullPathWithRoot); err != nil {
+		return err
+	}
+	// revisions left in the live tree belong to no object anymore
+	if err := store.removeVersions(fullPathWithRoot); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := store.moveVersions(trashed, fullPathWithRoot); err != nil {
+		return err
+	}
+
+	store.pruneEmptyDirs(filepath.Dir(trashed))
+
+	return nil
+}
+
+/* EmptyTrash permanently removes every trashed object. */
+func (store *DiskStore) EmptyTrash() error {
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	return store.FS.RemoveAll(store.trashRoot())
+}
+
+/* moveMetaFile renames the sidecar of the object at from, if any, to go with the object at to. */
+func (store *DiskStore) moveMetaFile(from, to string) error {
+	if err := store.FS.Rename(metaPath(from), metaPath(to)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
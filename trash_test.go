@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStorageTrash(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Trash:             true,
+	})
+	defer teardown(t, s)
+
+	meta := map[string]string{"owner": "ops"}
+	if _, err := s.WriteWithMeta("important", bytes.NewReader([]byte("do not lose")), meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete("important"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Has("important"); ok {
+		t.Error("expected a trashed object to count as absent")
+	}
+	if keys, _ := s.Keys(); len(keys) != 0 {
+		t.Errorf("expected trashed objects to not be listed, have %v", keys)
+	}
+
+	if err := s.Restore("important"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := s.ReadBytes("important"); string(b) != "do not lose" {
+		t.Errorf("unexpected restored content %q", b)
+	}
+	if have, _ := s.ReadMeta("important"); have["owner"] != "ops" {
+		t.Errorf("expected the metadata to be restored, have %v", have)
+	}
+
+	if err := s.Restore("important"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+
+	// a key written again after its delete is not clobbered by a restore
+	if err := s.Delete("important"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write("important", bytes.NewReader([]byte("newer"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore("important"); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("expected %v, have %v", ErrKeyExists, err)
+	}
+
+	if err := s.EmptyTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("important"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EmptyTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore("important"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v after emptying the trash, have %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestStorageTrashKeepsVersions(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		Trash:             true,
+		MaxVersions:       2,
+	})
+	defer teardown(t, s)
+
+	for _, content := range []string{"first", "second"} {
+		if _, err := s.Write("config", bytes.NewReader([]byte(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Delete("config"); err != nil {
+		t.Fatal(err)
+	}
+	_, path, _ := s.paths("config")
+	if _, err := os.Stat(versionPath(path, 1)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the revision to leave the live tree with the object, have %v", err)
+	}
+
+	if err := s.Restore("config"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := s.Versions("config"); len(versions) != 1 {
+		t.Fatalf("expected the revision to be restored, have %v", versions)
+	}
+	r, err := s.ReadVersion("config", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "first" {
+		t.Errorf("expected the restored revision, have %q", b)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+/* errExpired marks the ErrKeyNotFound of an object that outlived TTL. */
+var errExpired = errors.New("expired")
+
+/* expired reports whether the object described by info outlived TTL. */
+func (store *DiskStore) expired(info os.FileInfo) bool {
+	return store.TTL > 0 && time.Since(info.ModTime()) > store.TTL
+}
+
+func expiredError(key string) error {
+	return fmt.Errorf("%w (%s): %w", ErrKeyNotFound, key, errExpired)
+}
+
+/*
+expire lazily removes the object of key when err says it expired. Failures
+are only logged, the object is absent to the caller either way.
+*/
+func (store *DiskStore) expire(key string, err error) {
+	if !errors.Is(err, errExpired) || store.writable() != nil {
+		return
+	}
+
+	_, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return
+	}
+
+	if _, err := store.dropObject(fullPathWithRoot, store.expired); err != nil {
+		store.Logger.Error("removing expired object failed", "key", key, "path", fullPathWithRoot, "err", err)
+	}
+}
+
+/*
+ExpireNow walks the whole store, removing every object that outlived TTL, and
+returns how many it removed. Like lazily expired ones, they are removed
+outright without calling OnDelete. It's a no-op without a TTL.
+*/
+func (store *DiskStore) ExpireNow() (int, error) {
+	if err := store.writable(); err != nil {
+		return 0, err
+	}
+	if store.TTL <= 0 {
+		return 0, nil
+	}
+
+	var paths []string
+	err := store.walkDir(store.Root, func(path string, info os.FileInfo) error {
+		if store.expired(info) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		n, err := store.dropObject(path, store.expired)
+		if err != nil {
+			return removed, err
+		}
+		if n >= 0 {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
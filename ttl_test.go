@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStorageTTL(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		TTL:               time.Minute,
+	})
+	defer teardown(t, s)
+
+	for _, key := range []string{"fresh", "stale", "staler"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	past := time.Now().Add(-time.Hour)
+	for _, key := range []string{"stale", "staler"} {
+		_, fullPath, _ := s.paths(key)
+		if err := os.Chtimes(fullPath, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ok, err := s.Has("stale"); ok || err != nil {
+		t.Errorf("expected (false, nil) for an expired key, have (%v, %v)", ok, err)
+	}
+	_, fullPath, _ := s.paths("stale")
+	if _, err := os.Stat(fullPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the expired object to be removed lazily, have %v", err)
+	}
+
+	// a write restarts the window
+	if _, err := s.Write("stale", bytes.NewReader([]byte("again"))); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.ReadBytes("stale"); err != nil || string(b) != "again" {
+		t.Errorf("expected the rewritten object, have (%q, %v)", b, err)
+	}
+
+	if _, err := s.Read("staler"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, have %v", ErrKeyNotFound, err)
+	}
+
+	_, fullPath, _ = s.paths("fresh")
+	if err := os.Chtimes(fullPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.ExpireNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 expired object, have %d", n)
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("expected only the rewritten object to remain, have %v", keys)
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* ErrTxnDone is returned by the methods of a Txn that was committed or rolled back. */
+var ErrTxnDone = errors.New("transaction already committed or rolled back")
+
+/*
+journalDirName is the directory below Root holding the journals of committing
+transactions. A journal lists the renames and deletes of one Commit and exists
+from before its first change until after its last, so a crash in between
+leaves the journal behind and NewDiskStore replays it.
+*/
+const journalDirName = ".journal"
+
+/* journalFileSuffix marks a complete journal; anything else in the directory was never committed. */
+const journalFileSuffix = ".json"
+
+func (store *DiskStore) journalRoot() string {
+	return filepath.Join(store.Root, journalDirName)
+}
+
+/*
+Txn groups writes and deletes of several keys so that they take effect
+together or not at all, even across a crash. Writes are staged to temp files
+as they are made and the keys only change on Commit. A Txn is not safe for
+concurrent use.
+*/
+type Txn struct {
+	store *DiskStore
+	ops   []txnOp
+	done  bool
+}
+
+/* txnOp is a staged write or, with delete set, a delete of key. */
+type txnOp struct {
+	key              string
+	pathnameWithRoot string
+	fullPathWithRoot string
+
+	delete  bool
+	staged  *stagedObject
+	metaTmp string
+}
+
+/* journalOp is a txnOp as recorded in a journal. */
+type journalOp struct {
+	Key      string `json:"key"`
+	Path     string `json:"path"`
+	Temp     string `json:"temp,omitempty"`
+	MetaTemp string `json:"metaTemp,omitempty"`
+	Delete   bool   `json:"delete,omitempty"`
+
+	ContentType string `json:"contentType,omitempty"`
+}
+
+/*
+Begin starts a transaction. It must be ended with Commit or Rollback, the
+latter removing what was staged. Staged temp files still count as garbage for
+GC, so a transaction should be committed well within GCGracePeriod.
+*/
+func (store *DiskStore) Begin() *Txn {
+	return &Txn{store: store}
+}
+
+/* Write stages r to be stored under key on Commit. */
+func (txn *Txn) Write(key string, r io.Reader) error {
+	return txn.write(key, r, nil)
+}
+
+/* WriteWithMeta is like Write but also stages meta to replace the metadata of key. */
+func (txn *Txn) WriteWithMeta(key string, r io.Reader, meta map[string]string) error {
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return txn.write(key, r, meta)
+}
+
+func (txn *Txn) write(key string, r io.Reader, meta map[string]string) error {
+	store := txn.store
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return err
+	}
+
+	if err := store.FS.MkdirAll(pathnameWithRoot, store.DirMode); err != nil {
+		return err
+	}
+
+	// staged files are always synced, the journal must not point at lost content
+	staged, err := store.stageObject(context.Background(), pathnameWithRoot, fullPathWithRoot, r, true)
+	if err != nil {
+		return err
+	}
+	op := txnOp{key: key, pathnameWithRoot: pathnameWithRoot, fullPathWithRoot: fullPathWithRoot, staged: &staged}
+
+	if meta != nil {
+		b, err := json.Marshal(withContentType(meta, staged.contentType))
+		if err == nil {
+			op.metaTmp, err = store.stageFile(pathnameWithRoot, metaPath(fullPathWithRoot), b)
+		}
+		if err != nil {
+			store.discard(op)
+			return err
+		}
+	}
+
+	if err := store.fsyncDir(filepath.Dir(staged.tmp)); err != nil {
+		store.discard(op)
+		return err
+	}
+
+	txn.ops = append(txn.ops, op)
+	return nil
+}
+
+/* Delete stages the removal of key on Commit. */
+func (txn *Txn) Delete(key string) error {
+	store := txn.store
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := store.writable(); err != nil {
+		return err
+	}
+
+	pathnameWithRoot, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return err
+	}
+
+	txn.ops = append(txn.ops, txnOp{key: key, pathnameWithRoot: pathnameWithRoot, fullPathWithRoot: fullPathWithRoot, delete: true})
+	return nil
+}
+
+/*
+Commit applies the staged writes and deletes in the order they were made,
+holding the locks of all their keys. When it fails before recording its
+journal nothing has changed. When it fails after, the journal is left and the
+rest of the changes are applied by the next NewDiskStore on Root, so the keys
+of the transaction are in doubt until then.
+*/
+func (txn *Txn) Commit() error {
+	store := txn.store
+	if txn.done {
+		return ErrTxnDone
+	}
+	txn.done = true
+
+	if len(txn.ops) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+
+	paths := make([]string, len(txn.ops))
+	for i, op := range txn.ops {
+		paths[i] = op.fullPathWithRoot
+	}
+
+	unlock := store.locks.lock(paths...)
+	err := store.checkDeletes(txn.ops)
+	var journal string
+	if err == nil {
+		journal, err = store.writeJournal(txn.ops)
+	}
+	if err != nil {
+		unlock()
+		for _, op := range txn.ops {
+			store.discard(op)
+		}
+		return err
+	}
+
+	results, err := store.applyTxn(txn.ops)
+	if err == nil {
+		err = store.FS.Remove(journal)
+	}
+	unlock()
+
+	for i, op := range txn.ops {
+		if op.delete {
+			store.Metrics.ObserveDelete(time.Since(start), err)
+		} else {
+			store.Metrics.ObserveWrite(results[i].Bytes, time.Since(start), err)
+		}
+	}
+	if err != nil {
+		store.Logger.Error("commit failed", "journal", journal, "err", err)
+		return err
+	}
+
+	for i, op := range txn.ops {
+		if op.delete {
+			store.deleted(op.key)
+		} else {
+			store.wrote(op.key, results[i].Bytes)
+		}
+	}
+
+	return nil
+}
+
+/* Rollback discards the staged changes. Rolling back a committed Txn fails with ErrTxnDone. */
+func (txn *Txn) Rollback() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	txn.done = true
+
+	for _, op := range txn.ops {
+		txn.store.discard(op)
+	}
+	txn.ops = nil
+
+	return nil
+}
+
+/* discard removes the staged files of op. */
+func (store *DiskStore) discard(op txnOp) {
+	if op.staged != nil {
+		op.staged.release()
+		store.FS.Remove(op.staged.tmp)
+	}
+	if len(op.metaTmp) > 0 {
+		store.FS.Remove(op.metaTmp)
+	}
+}
+
+/* checkDeletes fails with ErrKeyNotFound for a delete of a missing key when StrictDelete is set. */
+func (store *DiskStore) checkDeletes(ops []txnOp) error {
+	if !store.StrictDelete {
+		return nil
+	}
+
+	for _, op := range ops {
+		if op.delete {
+			if _, err := store.stat(op.key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+applyTxn applies ops, syncing the directories they changed so the journal can
+go. The caller holds the locks of all their paths.
+*/
+func (store *DiskStore) applyTxn(ops []txnOp) ([]WriteResult, error) {
+	results := make([]WriteResult, len(ops))
+	dirs := map[string]bool{}
+
+	for i, op := range ops {
+		dirs[op.pathnameWithRoot] = true
+
+		if op.delete {
+			// pruned at the end, a later op may still rename into the directory
+			if err := store.deleteLocked(op.key, op.pathnameWithRoot, op.fullPathWithRoot, false); err != nil {
+				return results, err
+			}
+			continue
+		}
+		if op.staged == nil {
+			// a replayed write whose object was placed before the crash
+			if err := store.renameRetry(op.metaTmp, metaPath(op.fullPathWithRoot)); err != nil {
+				return results, err
+			}
+			continue
+		}
+
+		result, err := store.placeObject(op.pathnameWithRoot, op.fullPathWithRoot, *op.staged)
+		if err != nil {
+			return results, err
+		}
+		results[i] = result
+
+		if len(op.metaTmp) > 0 {
+			if err := store.renameRetry(op.metaTmp, metaPath(op.fullPathWithRoot)); err != nil {
+				return results, err
+			}
+		}
+	}
+
+	for dir := range dirs {
+		// deleting a key that never existed leaves no directory to sync
+		if err := store.fsyncDir(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return results, err
+		}
+	}
+
+	if store.PruneEmptyDirs {
+		for _, op := range ops {
+			if op.delete {
+				store.pruneEmptyDirs(op.pathnameWithRoot)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+/* writeJournal durably records ops in a new journal and returns its path. */
+func (store *DiskStore) writeJournal(ops []txnOp) (string, error) {
+	entries := make([]journalOp, len(ops))
+	for i, op := range ops {
+		entries[i] = journalOp{Key: op.key, Path: op.fullPathWithRoot, MetaTemp: op.metaTmp, Delete: op.delete}
+		if op.staged != nil {
+			entries[i].Temp = op.staged.tmp
+			entries[i].ContentType = op.staged.contentType
+		}
+	}
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+
+	root := store.journalRoot()
+	if err := store.FS.MkdirAll(root, store.DirMode); err != nil {
+		return "", err
+	}
+
+	tmp, err := store.stageFile(root, filepath.Join(root, "txn"), b)
+	if err != nil {
+		return "", err
+	}
+
+	journal := strings.TrimSuffix(tmp, tempFileSuffix) + journalFileSuffix
+	if err := store.renameRetry(tmp, journal); err != nil {
+		store.FS.Remove(tmp)
+		return "", err
+	}
+
+	if err := store.fsyncDir(root); err != nil {
+		store.FS.Remove(journal)
+		return "", err
+	}
+
+	return journal, nil
+}
+
+/* stageFile writes b to a synced temp file in dir for path and returns the temp file's path. */
+func (store *DiskStore) stageFile(dir, path string, b []byte) (string, error) {
+	file, err := store.createTempFile(dir, filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		store.FS.Remove(file.Name())
+		return "", err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		store.FS.Remove(file.Name())
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		store.FS.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+/*
+recoverJournals replays the journals commits left behind, oldest first, and
+removes journals that were never completed once they are older than
+GCGracePeriod. Replaying is idempotent: a staged file that is gone was already
+renamed into place, and as ops are applied in order so was everything before
+it, while deleting a missing object again changes nothing.
+*/
+func (store *DiskStore) recoverJournals() error {
+	root := store.journalRoot()
+
+	infos, err := readDirFS(store.FS, root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+
+	now := time.Now()
+	for _, info := range infos {
+		path := filepath.Join(root, info.Name())
+
+		if !strings.HasSuffix(info.Name(), journalFileSuffix) {
+			if now.Sub(info.ModTime()) > store.GCGracePeriod {
+				store.FS.Remove(path)
+			}
+			continue
+		}
+
+		if err := store.replayJournal(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* replayJournal applies what is left of the journal at path and removes it. */
+func (store *DiskStore) replayJournal(path string) error {
+	b, err := readFileFS(store.FS, path)
+	if err != nil {
+		return err
+	}
+
+	var entries []journalOp
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+
+	// redoing a delete before a write of the same key that was applied would lose the write
+	applied := -1
+	for i, entry := range entries {
+		if len(entry.Temp) == 0 {
+			continue
+		}
+		if _, err := store.FS.Stat(entry.Temp); errors.Is(err, os.ErrNotExist) {
+			applied = i
+		} else if err != nil {
+			return err
+		}
+	}
+
+	ops := make([]txnOp, 0, len(entries))
+	paths := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		op := txnOp{key: entry.Key, pathnameWithRoot: filepath.Dir(entry.Path), fullPathWithRoot: entry.Path, delete: entry.Delete}
+		paths = append(paths, entry.Path)
+		if i < applied {
+			continue
+		}
+
+		if len(entry.Temp) > 0 {
+			if info, err := store.FS.Stat(entry.Temp); err == nil {
+				op.staged = &stagedObject{tmp: entry.Temp, info: info, n: info.Size(), contentType: entry.ContentType, release: func() {}}
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if len(entry.MetaTemp) > 0 {
+			if _, err := store.FS.Stat(entry.MetaTemp); err == nil {
+				op.metaTmp = entry.MetaTemp
+			}
+		}
+
+		// a write with nothing staged left was applied; unlike a delete it can't be redone
+		if op.delete || op.staged != nil || len(op.metaTmp) > 0 {
+			ops = append(ops, op)
+		}
+	}
+
+	defer store.locks.lock(paths...)()
+
+	store.Logger.Info("replaying journal", "journal", path, "ops", len(ops))
+	if _, err := store.applyTxn(ops); err != nil {
+		return err
+	}
+	store.counters.invalidate()
+
+	return store.FS.Remove(path)
+}
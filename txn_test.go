@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageTxnCommit(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("stale", bytes.NewReader([]byte("old"))); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := s.Begin()
+	if err := txn.WriteWithMeta("report", bytes.NewReader([]byte("content")), map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete("stale"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := s.Has("report"); ok {
+		t.Error("expected a staged write to stay invisible until Commit")
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := s.ReadBytes("report"); string(b) != "content" {
+		t.Errorf("expected the committed content, have %q", b)
+	}
+	if meta, _ := s.ReadMeta("report"); meta["owner"] != "ops" {
+		t.Errorf("expected the committed metadata, have %v", meta)
+	}
+	if ok, _ := s.Has("stale"); ok {
+		t.Error("expected the committed delete to remove the key")
+	}
+
+	if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Errorf("expected ErrTxnDone committing twice, have %v", err)
+	}
+}
+
+func TestStorageTxnRollback(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	txn := s.Begin()
+	if err := txn.Write("draft", bytes.NewReader([]byte("content"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := s.Has("draft"); ok {
+		t.Error("expected a rolled back write to leave no key")
+	}
+
+	err := filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && isTempFile(info.Name()) {
+			t.Errorf("expected Rollback to remove its staged files, found %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageTxnRecovery(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("stale", bytes.NewReader([]byte("old"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// stage and record a commit, then crash before applying it
+	txn := s.Begin()
+	if err := txn.Write("report", bytes.NewReader([]byte("content"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete("stale"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.writeJournal(txn.ops); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newStorageWithOptions(t, StorageOptions{PathTransformFunc: CASPathTransformFunc})
+
+	if b, _ := s.ReadBytes("report"); string(b) != "content" {
+		t.Errorf("expected recovery to apply the journaled write, have %q", b)
+	}
+	if ok, _ := s.Has("stale"); ok {
+		t.Error("expected recovery to apply the journaled delete")
+	}
+
+	infos, err := readDirFS(s.FS, s.journalRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 0 {
+		t.Errorf("expected recovery to remove the journal, have %d entries", len(infos))
+	}
+}
+
+func TestStorageTxnRecoveryAfterApply(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	if _, err := s.Write("report", bytes.NewReader([]byte("old"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// replace the key within one commit, then crash before removing the journal
+	txn := s.Begin()
+	if err := txn.Delete("report"); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Write("report", bytes.NewReader([]byte("new"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.writeJournal(txn.ops); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.applyTxn(txn.ops); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newStorageWithOptions(t, StorageOptions{PathTransformFunc: CASPathTransformFunc})
+
+	if b, err := s.ReadBytes("report"); string(b) != "new" {
+		t.Errorf("expected replaying to keep the applied write, have %q (%v)", b, err)
+	}
+}
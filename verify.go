@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+/*
+VerifiedRead is like Read but hashes the content and returns ErrCorrupted when
+the digest no longer matches the object's Filename. Only content-addressed
+stores can be verified.
+*/
+func (store *DiskStore) VerifiedRead(key string) (io.Reader, error) {
+	if !store.ContentAddressed {
+		return nil, ErrNotContentAddressed
+	}
+
+	buf := new(bytes.Buffer)
+	if err := store.verify(context.Background(), key, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+/* Verify is VerifyContext without a deadline. */
+func (store *DiskStore) Verify() ([]string, error) {
+	return store.VerifyContext(context.Background())
+}
+
+/*
+VerifyContext scrubs the whole store: every object is streamed through Hash and
+the keys whose content no longer matches their digest are returned. The scrub
+stops with ctx.Err() once ctx is done, so its duration can be bounded. With
+ChecksumIndex, objects never verified come first and the others follow from
+the longest ago verified, so bounded scrubs take turns covering the store.
+*/
+func (store *DiskStore) VerifyContext(ctx context.Context) ([]string, error) {
+	if !store.ContentAddressed {
+		return nil, ErrNotContentAddressed
+	}
+
+	type object struct {
+		filename string
+		verified time.Time
+	}
+	var objects []object
+	err := store.walkDir(filepath.Join(store.Root, store.prefix), func(path string, info os.FileInfo) error {
+		objects = append(objects, object{store.filenameOf(info.Name()), store.checksums.verifiedAt(path)})
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	sort.SliceStable(objects, func(i, j int) bool { return objects[i].verified.Before(objects[j].verified) })
+
+	corrupted := []string{}
+	for _, object := range objects {
+		err := store.verify(ctx, object.filename, io.Discard)
+		if errors.Is(err, ErrCorrupted) {
+			corrupted = append(corrupted, object.filename)
+			continue
+		}
+		// removed since the walk
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return corrupted, err
+		}
+	}
+
+	return corrupted, nil
+}
+
+/*
+verify streams the object under key into w while hashing it, and returns
+ErrCorrupted if the digest does not match the object's Filename.
+*/
+func (store *DiskStore) verify(ctx context.Context, key string, w io.Writer) error {
+	file, err := store.readStream(key)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	hasher := store.Hash()
+	if _, err := copyContext(ctx, io.MultiWriter(w, hasher), file); err != nil {
+		return err
+	}
+
+	if digest := hex.EncodeToString(hasher.Sum(nil)); digest != store.PathTransformFunc(key).Filename {
+		return fmt.Errorf("%w (%s): have digest %s", ErrCorrupted, key, digest)
+	}
+
+	if _, fullPathWithRoot, err := store.paths(key); err == nil {
+		store.checksums.verified(fullPathWithRoot)
+	}
+	return nil
+}
+
+/*
+verifyingReader is the stream of a VerifyReads store. The corruption of an
+object only shows once all of it was hashed, so the Read reaching the end of
+the content returns ErrCorrupted instead of io.EOF, as do all Reads after it;
+the bytes read before were handed out unverified. A stream closed before its
+end is never verified. The content has to pass through Read to be hashed, so
+copying it, e.g. into an http.ResponseWriter, forgoes the sendfile fast path.
+*/
+type verifyingReader struct {
+	io.ReadCloser
+	store  *DiskStore
+	key    string
+	hasher hash.Hash
+
+	// done is set once the end was reached, err is then its ErrCorrupted or nil
+	done bool
+	err  error
+}
+
+func (store *DiskStore) verifyingReader(key string, r io.ReadCloser) *verifyingReader {
+	return &verifyingReader{ReadCloser: r, store: store, key: key, hasher: store.Hash()}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.done {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.hasher.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+
+	r.done = true
+	if digest := hex.EncodeToString(r.hasher.Sum(nil)); digest != r.store.PathTransformFunc(r.key).Filename {
+		r.err = fmt.Errorf("%w (%s): have digest %s", ErrCorrupted, r.key, digest)
+		return n, r.err
+	}
+	if _, fullPathWithRoot, err := r.store.paths(r.key); err == nil {
+		r.store.checksums.verified(fullPathWithRoot)
+	}
+
+	return n, io.EOF
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStorageVerifiedRead(t *testing.T) {
+	s := newContentAddressedStorage(t)
+	defer teardown(t, s)
+
+	data := []byte("some jpg bytes")
+	key := writeContentAddressed(t, s, data)
+
+	r, err := s.VerifiedRead(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != string(data) {
+		t.Errorf("expected %s have %s", data, b)
+	}
+
+	_, path, _ := s.paths(key)
+	if err := os.WriteFile(path, []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifiedRead(key); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected %v, have %v", ErrCorrupted, err)
+	}
+
+	plain := newStorage(t)
+	defer teardown(t, plain)
+
+	if _, err := plain.VerifiedRead(key); !errors.Is(err, ErrNotContentAddressed) {
+		t.Errorf("expected %v, have %v", ErrNotContentAddressed, err)
+	}
+}
+
+func TestStorageVerify(t *testing.T) {
+	s := newContentAddressedStorage(t)
+	defer teardown(t, s)
+
+	var keys []string
+	for _, data := range []string{"first", "second", "third"} {
+		keys = append(keys, writeContentAddressed(t, s, []byte(data)))
+	}
+
+	_, path, _ := s.paths(keys[1])
+	if err := os.WriteFile(path, []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted, err := s.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corrupted) != 1 || corrupted[0] != keys[1] {
+		t.Errorf("expected [%s] to be corrupted, have %v", keys[1], corrupted)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.VerifyContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, have %v", context.Canceled, err)
+	}
+}
+
+func TestStorageVerifyReads(t *testing.T) {
+	for _, bufferSize := range []int{0, 4096} {
+		s := newStorageWithOptions(t, StorageOptions{
+			Root:              "casroot",
+			PathTransformFunc: NewDigestPathTransformFunc(5),
+			ContentAddressed:  true,
+			VerifyReads:       true,
+			ReadBufferSize:    bufferSize,
+		})
+
+		data := []byte("some jpg bytes")
+		key := writeContentAddressed(t, s, data)
+
+		if b, err := s.ReadBytes(key); err != nil || string(b) != string(data) {
+			t.Errorf("expected %s have %s, %v", data, b, err)
+		}
+
+		_, path, _ := s.paths(key)
+		if err := os.WriteFile(path, []byte("bit rot"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := s.Read(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		if !errors.Is(err, ErrCorrupted) {
+			t.Errorf("expected the last Read to fail with %v, have %v", ErrCorrupted, err)
+		}
+		if string(b) != "bit rot" {
+			t.Errorf("expected the content read before the end, have %q", b)
+		}
+		if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("expected Reads after the end to keep failing, have %v", err)
+		}
+		r.Close()
+
+		teardown(t, s)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Previous revisions of an object are kept next to it as "<Filename>.v<N>",
+numbered upwards from 1, when MaxVersions is set. They are stored exactly as
+the object was, encoded or not, and don't count towards Stats or MaxBytes.
+*/
+const versionFileInfix = ".v"
+
+/* isVersionFile reports whether name is a kept revision of an object. */
+func isVersionFile(name string) bool {
+	_, _, ok := parseVersionFile(name)
+	return ok
+}
+
+/* parseVersionFile splits a revision file name into the object's Filename and the version. */
+func parseVersionFile(name string) (string, int, bool) {
+	i := strings.LastIndex(name, versionFileInfix)
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	digits := name[i+len(versionFileInfix):]
+	if len(digits) == 0 || strings.TrimLeft(digits, "0123456789") != "" {
+		return "", 0, false
+	}
+
+	version, err := strconv.Atoi(digits)
+	if err != nil || version <= 0 {
+		return "", 0, false
+	}
+
+	return name[:i], version, true
+}
+
+func versionPath(fullPathWithRoot string, version int) string {
+	return fullPathWithRoot + versionFileInfix + strconv.Itoa(version)
+}
+
+/* versioned reports whether writes keep the content they replace. */
+func (store *DiskStore) versioned() bool {
+	// content-addressed objects never change, there is nothing to keep
+	return store.MaxVersions > 0 && !store.ContentAddressed
+}
+
+/*
+Versions returns the kept revisions of the object under key, oldest first. The
+numbers can be passed to ReadVersion.
+*/
+func (store *DiskStore) Versions(key string) ([]int, error) {
+	if _, err := store.stat(key); err != nil {
+		return nil, err
+	}
+
+	_, fullPathWithRoot, _ := store.paths(key)
+
+	return store.versions(fullPathWithRoot)
+}
+
+/* ReadVersion returns a stream over the given revision of the object under key. */
+func (store *DiskStore) ReadVersion(key string, version int) (io.ReadCloser, error) {
+	_, fullPathWithRoot, err := store.paths(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if version <= 0 {
+		return nil, fmt.Errorf("%w (%s): version %d", ErrKeyNotFound, key, version)
+	}
+
+	start := time.Now()
+	r, err := store.openObject(key, versionPath(fullPathWithRoot, version))
+	return store.observeRead(start, r, err)
+}
+
+/* versions lists the revisions kept of the object at fullPathWithRoot, oldest first. */
+func (store *DiskStore) versions(fullPathWithRoot string) ([]int, error) {
+	dir, err := store.FS.Open(filepath.Dir(fullPathWithRoot))
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	filename := filepath.Base(fullPathWithRoot)
+
+	versions := []int{}
+	for _, info := range infos {
+		if name, version, ok := parseVersionFile(info.Name()); ok && name == filename {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+
+	return versions, nil
+}
+
+/*
+keepVersion saves the current content of the object at fullPathWithRoot as
+its next revision and prunes the revisions beyond MaxVersions. The object
+itself stays in place, so it can still be replaced by an atomic rename.
+*/
+func (store *DiskStore) keepVersion(pathnameWithRoot, fullPathWithRoot string) error {
+	versions, err := store.versions(fullPathWithRoot)
+	if err != nil {
+		return err
+	}
+
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1] + 1
+	}
+
+	if err := store.copyFile(pathnameWithRoot, fullPathWithRoot, versionPath(fullPathWithRoot, next)); err != nil {
+		return err
+	}
+
+	versions = append(versions, next)
+	for len(versions) > store.MaxVersions {
+		if err := store.FS.Remove(versionPath(fullPathWithRoot, versions[0])); err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+
+	return nil
+}
+
+/* moveVersions renames the revisions of the object at from, if any, to go with the object at to. */
+func (store *DiskStore) moveVersions(from, to string) error {
+	versions, err := store.versions(from)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		if err := store.FS.Rename(versionPath(from, version), versionPath(to, version)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* removeVersions removes every revision kept of the object at fullPathWithRoot. */
+func (store *DiskStore) removeVersions(fullPathWithRoot string) error {
+	versions, err := store.versions(fullPathWithRoot)
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		if err := store.FS.Remove(versionPath(fullPathWithRoot, version)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+copyFile copies the file at src to dst in dir as is, hardlinking when the
+filesystem can and going through a temp file otherwise.
+*/
+func (store *DiskStore) copyFile(dir, src, dst string) error {
+	if linker, ok := store.FS.(Linker); ok {
+		if err := linker.Link(src, dst); err == nil {
+			return nil
+		}
+	}
+
+	in, err := store.openRetry(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return store.writeFile(dir, dst, in)
+}
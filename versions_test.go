@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestStorageVersions(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxVersions:       2,
+	})
+	defer teardown(t, s)
+
+	for i := 1; i <= 4; i++ {
+		if _, err := s.Write("config", bytes.NewReader([]byte(fmt.Sprintf("revision %d", i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := s.Versions("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(versions) != "[2 3]" {
+		t.Errorf("expected versions [2 3], have %v", versions)
+	}
+
+	r, err := s.ReadVersion("config", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "revision 3" {
+		t.Errorf("unexpected content of version 3 %q", b)
+	}
+
+	if _, err := s.ReadVersion("config", 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the pruned version to be gone, have %v", err)
+	}
+
+	if keys, _ := s.Keys(); len(keys) != 1 {
+		t.Errorf("expected versions to not be listed, have %v", keys)
+	}
+
+	if err := s.Delete("config"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadVersion("config", 3); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected Delete to remove the versions, have %v", err)
+	}
+}
+
+func TestStorageVersionsCopyMove(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxVersions:       2,
+	})
+	defer teardown(t, s)
+
+	for _, key := range []string{"copied", "moved"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte("previous "+key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"src", "other"} {
+		if _, err := s.Write(key, bytes.NewReader([]byte("new"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Copy("src", "copied"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("other", "moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"copied", "moved"} {
+		if versions, _ := s.Versions(key); fmt.Sprint(versions) != "[1]" {
+			t.Errorf("expected %s to keep one revision, have %v", key, versions)
+			continue
+		}
+		r, err := s.ReadVersion(key, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		if string(b) != "previous "+key {
+			t.Errorf("expected the replaced content of %s as its revision, have %q", key, b)
+		}
+		if b, _ := s.ReadBytes(key); string(b) != "new" {
+			t.Errorf("expected %s to hold the new content, have %q", key, b)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+/* errWriteAborted ends the write of an ObjectWriter whose Abort was called. */
+var errWriteAborted = errors.New("write aborted")
+
+/*
+ObjectWriter stores the bytes written to it under a key, for content that is
+produced incrementally rather than available as an io.Reader. Its content goes
+to a temp file exactly like a Write, so the key only changes once Close
+succeeds. The key stays locked for other writers until Close or Abort.
+*/
+type ObjectWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+	n    int64
+	err  error
+}
+
+/*
+Writer returns an ObjectWriter for key. The caller must end it with Close,
+which stores the content, or with Abort, which discards it.
+*/
+func (store *DiskStore) Writer(key string) (*ObjectWriter, error) {
+	if err := store.writable(); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := store.paths(key); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	ow := &ObjectWriter{pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		result, err := store.writeStream(context.Background(), key, pr)
+		// unblocks pending Writes once the write failed, e.g. on a full quota
+		pr.CloseWithError(err)
+		ow.n = result.Bytes
+		ow.done <- err
+	}()
+
+	return ow, nil
+}
+
+func (ow *ObjectWriter) Write(p []byte) (int, error) {
+	return ow.pw.Write(p)
+}
+
+/* Close stores the written content under the key and returns any error doing so. */
+func (ow *ObjectWriter) Close() error {
+	return ow.finish(nil)
+}
+
+/* Abort discards the written content, leaving the key as it was. */
+func (ow *ObjectWriter) Abort() error {
+	err := ow.finish(errWriteAborted)
+	if errors.Is(err, errWriteAborted) {
+		return nil
+	}
+	return err
+}
+
+/* N returns the number of bytes stored by a successful Close. */
+func (ow *ObjectWriter) N() int64 {
+	return ow.n
+}
+
+func (ow *ObjectWriter) finish(cause error) error {
+	if ow.done != nil {
+		ow.pw.CloseWithError(cause)
+		ow.err = <-ow.done
+		ow.done = nil
+	}
+	return ow.err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageWriter(t *testing.T) {
+	s := newStorage(t)
+	defer teardown(t, s)
+
+	w, err := s.Writer("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewEncoder(w).Encode(map[string]int{"replicas": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := s.ReadBytes("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"replicas\":3}\n" {
+		t.Errorf("unexpected content %q", b)
+	}
+	if w.N() != int64(len(b)) {
+		t.Errorf("expected N %d, have %d", len(b), w.N())
+	}
+
+	// an aborted write leaves the previous content and no temp file behind
+	w, err = s.Writer("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("half")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := s.ReadBytes("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, b) {
+		t.Errorf("expected the aborted write to keep %q, have %q", b, after)
+	}
+
+	pathKey := s.PathTransformFunc("config")
+	entries, err := os.ReadDir(filepath.Join(s.Root, pathKey.Pathname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the object file, have %d entries", len(entries))
+	}
+}
+
+func TestStorageWriterQuota(t *testing.T) {
+	s := newStorageWithOptions(t, StorageOptions{
+		PathTransformFunc: CASPathTransformFunc,
+		MaxObjectBytes:    4,
+	})
+	defer teardown(t, s)
+
+	w, err := s.Writer("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("too many bytes"))
+	if err := w.Close(); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("expected %v, have %v", ErrObjectTooLarge, err)
+	}
+	if ok, _ := s.Has("big"); ok {
+		t.Error("expected to NOT have key big")
+	}
+}